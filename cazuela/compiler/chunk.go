@@ -0,0 +1,95 @@
+package compiler
+
+import "fmt"
+
+// opcodes
+const (
+	OpConstant = iota // [constant u16] pushes a constant
+	OpNil
+	OpTrue
+	OpFalse
+	OpPop
+
+	OpGetLocal     // [slot u8]
+	OpSetLocal     // [slot u8]
+	OpGetCell      // [slot u8] reads a local captured by a closure
+	OpSetCell      // [slot u8]
+	OpDeclareLocal // [slot u8] no-op, patched into OpBoxLocal when the local is captured
+	OpBoxLocal     // [slot u8] moves the value of a local into a new cell
+	OpGetUpvalue   // [index u8]
+	OpSetUpvalue   // [index u8]
+	OpGetGlobal    // [name u16]
+	OpSetGlobal    // [name u16]
+	OpDefineGlobal // [name u16]
+
+	OpEqual
+	OpNotEqual
+	OpGreater
+	OpGreaterEqual
+	OpLess
+	OpLessEqual
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpModulo
+	OpPower
+	OpNot
+	OpNegate
+	OpRange     // start end → range
+	OpRangeStep // start end step → range
+	OpMatch     // subject pattern → bool
+
+	OpPrint
+	OpExpression // pops the value of an expression statement, printing it in the REPL
+
+	OpJump         // [offset u16]
+	OpJumpIfFalse  // [offset u16] leaves the condition on the stack
+	OpJumpIfNotNil // [offset u16] leaves the value on the stack
+	OpLoop         // [offset u16] jumps backwards
+	OpIterate      // turns the value on top of the stack into an iterator
+	OpNext         // [slot u8] [offset u16] pushes the next value of the iterator in slot, or jumps when exhausted
+	OpClose        // [slot u8] closes the iterator in slot when the loop is left, see values.Abandon
+	OpCall         // [arguments u8]
+	OpTailCall     // [arguments u8] like OpCall, but a closure reuses the frame of the caller; followed by OpReturn
+	OpSpawn        // [arguments u8] like OpCall, but runs as a task and pushes it
+	OpClosure      // [function u16] followed by ([isLocal u8] [index u8]) per upvalue
+	OpReturn
+	OpYield
+)
+
+// A Chunk is a compiled sequence of instructions, along with the constants they use
+// and the source line of every byte
+type Chunk struct {
+	Code      []byte
+	Constants []interface{}
+	Lines     []int
+}
+
+// A Function is the compiled form of a FnDecl, or of the whole program
+type Function struct {
+	Name         string
+	Arity        int
+	UpvalueCount int
+	IsGenerator  bool
+	Chunk        Chunk
+}
+
+func (f *Function) String() string {
+	return fmt.Sprintf("<fn %v>", f.Name)
+}
+
+func (c *Chunk) write(b byte, line int) {
+	c.Code = append(c.Code, b)
+	c.Lines = append(c.Lines, line)
+}
+
+func (c *Chunk) addConstant(value interface{}) int {
+	c.Constants = append(c.Constants, value)
+	return len(c.Constants) - 1
+}
+
+// ReadShort decodes the two byte operand starting at offset
+func (c *Chunk) ReadShort(offset int) int {
+	return int(c.Code[offset])<<8 | int(c.Code[offset+1])
+}
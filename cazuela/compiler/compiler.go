@@ -0,0 +1,724 @@
+package compiler
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"fmt"
+)
+
+/*
+Turns the AST into bytecode for the vm package, one Function per FnDecl.
+
+Variables declared at the top level are globals, looked up by name at run time.
+Everything else lives in a slot of the function's stack window. When an inner
+function captures a local, the local is moved into a heap cell instead: every
+instruction touching it is recorded while compiling, and once its scope ends
+they are patched into their cell variants, so closures (and tasks running them)
+share the cell rather than a stack slot.
+
+The local functions of a block get their slots when the block starts, so the functions
+nested in them can capture those declared further down. Until its declaration is
+reached, the block itself doesn't see such a function.
+*/
+
+const maxLocals = 256
+
+type local struct {
+	name       string
+	depth      int
+	captured   bool
+	declaredAt int
+	accesses   []int
+	pending    bool
+}
+
+type upvalue struct {
+	index   int
+	isLocal bool
+}
+
+type loop struct {
+	depth         int
+	iterator      int // the slot of the iterator of a "por cada", -1 for other loops
+	breakJumps    []int
+	continueJumps []int
+	enclosing     *loop
+}
+
+type compiler struct {
+	enclosing  *compiler
+	function   *Function
+	locals     []local
+	upvalues   []upvalue
+	scopeDepth int
+	loop       *loop
+	line       int
+}
+
+// Compile turns a program into the function the VM starts running
+func Compile(stmts []parser.Stmt) *Function {
+	c := newCompiler(nil, "<principal>", false)
+
+	for _, s := range stmts {
+		c.statement(s)
+	}
+
+	return c.finish()
+}
+
+func newCompiler(enclosing *compiler, name string, isGenerator bool) *compiler {
+	c := &compiler{
+		enclosing: enclosing,
+		function:  &Function{Name: name, IsGenerator: isGenerator},
+	}
+
+	if enclosing != nil {
+		c.line = enclosing.line
+	}
+
+	// Slot 0 holds the function being called
+	c.locals = append(c.locals, local{name: "", depth: 0, declaredAt: -1})
+	return c
+}
+
+func (c *compiler) finish() *Function {
+	c.emit(OpNil)
+	c.emit(OpReturn)
+
+	for i := range c.locals {
+		c.patchLocal(&c.locals[i])
+	}
+
+	c.function.UpvalueCount = len(c.upvalues)
+	return c.function
+}
+
+func (c *compiler) error(message string) {
+	errorHandler.RaiseError(errorHandler.CodeSyntaxError, message, c.line, "[Compilado]", true)
+}
+
+func (c *compiler) at(token lexer.Token) {
+	if token.Line > 0 {
+		c.line = token.Line
+	}
+}
+
+func (c *compiler) chunk() *Chunk {
+	return &c.function.Chunk
+}
+
+func (c *compiler) emit(bytes ...byte) {
+	for _, b := range bytes {
+		c.chunk().write(b, c.line)
+	}
+}
+
+func (c *compiler) emitShort(op byte, operand int) {
+	c.emit(op, byte(operand>>8), byte(operand))
+}
+
+func (c *compiler) makeConstant(value interface{}) int {
+	index := c.chunk().addConstant(value)
+	if index > 0xFFFF {
+		c.error("Demasiadas constantes en una sola función.")
+	}
+	return index
+}
+
+func (c *compiler) emitConstant(value interface{}) {
+	c.emitShort(OpConstant, c.makeConstant(value))
+}
+
+// emitJump writes a jump with a placeholder offset, returning where the offset is
+func (c *compiler) emitJump(op byte) int {
+	c.emit(op, 0xFF, 0xFF)
+	return len(c.chunk().Code) - 2
+}
+
+func (c *compiler) patchJump(offset int) {
+	jump := len(c.chunk().Code) - offset - 2
+	if jump > 0xFFFF {
+		c.error("Demasiado código que saltar.")
+	}
+
+	c.chunk().Code[offset] = byte(jump >> 8)
+	c.chunk().Code[offset+1] = byte(jump)
+}
+
+func (c *compiler) emitLoop(start int) {
+	offset := len(c.chunk().Code) - start + 3
+	if offset > 0xFFFF {
+		c.error("El cuerpo del ciclo es demasiado grande.")
+	}
+	c.emitShort(OpLoop, offset)
+}
+
+func (c *compiler) beginScope() {
+	c.scopeDepth++
+}
+
+func (c *compiler) endScope() {
+	c.scopeDepth--
+
+	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scopeDepth {
+		c.patchLocal(&c.locals[len(c.locals)-1])
+		c.emit(OpPop)
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+// patchLocal rewrites every instruction touching a captured local into its cell variant
+func (c *compiler) patchLocal(l *local) {
+	if !l.captured {
+		return
+	}
+
+	code := c.chunk().Code
+	code[l.declaredAt] = OpBoxLocal
+
+	for _, offset := range l.accesses {
+		if code[offset] == OpGetLocal {
+			code[offset] = OpGetCell
+		} else {
+			code[offset] = OpSetCell
+		}
+	}
+}
+
+// addLocal declares the value on top of the stack as a local variable
+func (c *compiler) addLocal(name string) int {
+	if len(c.locals) >= maxLocals {
+		c.error("Demasiadas variables locales en una función.")
+		return 0
+	}
+
+	slot := len(c.locals)
+	c.locals = append(c.locals, local{name: name, depth: c.scopeDepth, declaredAt: len(c.chunk().Code)})
+	c.emit(OpDeclareLocal, byte(slot))
+	return slot
+}
+
+// resolveLocal finds a local by name. Functions whose declaration hasn't been reached are
+// only found by the functions nested in the block, when looking for upvalues
+func (c *compiler) resolveLocal(name string, nested bool) int {
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if c.locals[i].name == name && (nested || !c.locals[i].pending) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *compiler) resolveUpvalue(name string) int {
+	if c.enclosing == nil {
+		return -1
+	}
+
+	if slot := c.enclosing.resolveLocal(name, true); slot != -1 {
+		c.enclosing.locals[slot].captured = true
+		return c.addUpvalue(slot, true)
+	}
+
+	if index := c.enclosing.resolveUpvalue(name); index != -1 {
+		return c.addUpvalue(index, false)
+	}
+
+	return -1
+}
+
+func (c *compiler) addUpvalue(index int, isLocal bool) int {
+	for i, u := range c.upvalues {
+		if u.index == index && u.isLocal == isLocal {
+			return i
+		}
+	}
+
+	if len(c.upvalues) >= maxLocals {
+		c.error("Demasiadas variables capturadas en una función.")
+		return 0
+	}
+
+	c.upvalues = append(c.upvalues, upvalue{index, isLocal})
+	return len(c.upvalues) - 1
+}
+
+func (c *compiler) emitLocalAccess(op byte, slot int) {
+	c.locals[slot].accesses = append(c.locals[slot].accesses, len(c.chunk().Code))
+	c.emit(op, byte(slot))
+}
+
+func (c *compiler) getVariable(name lexer.Token) {
+	c.at(name)
+
+	if slot := c.resolveLocal(name.Lexeme, false); slot != -1 {
+		c.emitLocalAccess(OpGetLocal, slot)
+	} else if index := c.resolveUpvalue(name.Lexeme); index != -1 {
+		c.emit(OpGetUpvalue, byte(index))
+	} else {
+		c.emitShort(OpGetGlobal, c.makeConstant(name.Lexeme))
+	}
+}
+
+func (c *compiler) setVariable(name lexer.Token) {
+	c.at(name)
+
+	if slot := c.resolveLocal(name.Lexeme, false); slot != -1 {
+		c.emitLocalAccess(OpSetLocal, slot)
+	} else if index := c.resolveUpvalue(name.Lexeme); index != -1 {
+		c.emit(OpSetUpvalue, byte(index))
+	} else {
+		c.emitShort(OpSetGlobal, c.makeConstant(name.Lexeme))
+	}
+}
+
+// defineVariable binds the value on top of the stack, as a global at the top level or as a local otherwise
+func (c *compiler) defineVariable(name lexer.Token) {
+	if c.scopeDepth == 0 {
+		c.emitShort(OpDefineGlobal, c.makeConstant(name.Lexeme))
+		return
+	}
+
+	c.addLocal(name.Lexeme)
+}
+
+// popLoopLocals discards the locals declared inside the current loop before jumping out of it
+func (c *compiler) popLoopLocals() {
+	for i := len(c.locals) - 1; i >= 0 && c.locals[i].depth > c.loop.depth; i-- {
+		c.emit(OpPop)
+	}
+}
+
+func (c *compiler) statement(s parser.Stmt) {
+	if s == nil {
+		return
+	}
+
+	if line := parser.StatementLine(s); line > 0 {
+		c.line = line
+	}
+
+	if v, ok := s.(parser.Statement); ok {
+		c.expression(v.Expr)
+		c.emit(OpExpression)
+	} else if v, ok := s.(parser.Print); ok {
+		c.expression(v.Expr)
+		c.emit(OpPrint)
+	} else if v, ok := s.(parser.Declaration); ok {
+		c.declaration(v)
+	} else if v, ok := s.(parser.Block); ok {
+		c.beginScope()
+		c.declareFunctions(v.Statements)
+		for _, st := range v.Statements {
+			c.statement(st)
+		}
+		c.endScope()
+	} else if v, ok := s.(parser.If); ok {
+		c.ifStatement(v)
+	} else if v, ok := s.(parser.While); ok {
+		c.whileStatement(v)
+	} else if v, ok := s.(parser.FnDecl); ok {
+		c.functionDeclaration(v)
+	} else if v, ok := s.(parser.ForEach); ok {
+		c.forEachStatement(v)
+	} else if v, ok := s.(parser.Switch); ok {
+		c.switchStatement(v)
+	} else if v, ok := s.(parser.ReturnStmt); ok {
+		c.at(v.Keyword)
+		if call, ok := v.Value.(parser.CallExpression); ok {
+			c.call(call, OpTailCall)
+		} else {
+			c.optionalExpression(v.Value)
+			c.closeIterators()
+		}
+		c.emit(OpReturn)
+	} else if v, ok := s.(parser.YieldStmt); ok {
+		c.at(v.Keyword)
+		c.optionalExpression(v.Value)
+		c.emit(OpYield)
+	} else if v, ok := s.(parser.BreakStmt); ok {
+		c.at(v.Keyword)
+		c.popLoopLocals()
+		c.loop.breakJumps = append(c.loop.breakJumps, c.emitJump(OpJump))
+	} else if v, ok := s.(parser.ContinueStmt); ok {
+		c.at(v.Keyword)
+		c.popLoopLocals()
+		c.loop.continueJumps = append(c.loop.continueJumps, c.emitJump(OpJump))
+	}
+}
+
+func (c *compiler) optionalExpression(expr parser.Expression) {
+	if expr == nil {
+		c.emit(OpNil)
+	} else {
+		c.expression(expr)
+	}
+}
+
+// The initializer is compiled before the local exists, so it still sees any outer variable with the same name
+func (c *compiler) declaration(v parser.Declaration) {
+	c.optionalExpression(v.Initializer)
+	c.at(v.Name)
+	c.defineVariable(v.Name)
+}
+
+func (c *compiler) ifStatement(v parser.If) {
+	c.expression(v.Condition)
+
+	thenJump := c.emitJump(OpJumpIfFalse)
+	c.emit(OpPop)
+	c.statement(v.ThenBranch)
+
+	elseJump := c.emitJump(OpJump)
+	c.patchJump(thenJump)
+	c.emit(OpPop)
+	c.statement(v.ElseBranch)
+
+	c.patchJump(elseJump)
+}
+
+func (c *compiler) beginLoop() {
+	c.loop = &loop{depth: c.scopeDepth, iterator: -1, enclosing: c.loop}
+}
+
+// closeIterators closes the iterators of every loop a return leaves
+func (c *compiler) closeIterators() {
+	for l := c.loop; l != nil; l = l.enclosing {
+		if l.iterator >= 0 {
+			c.emit(OpClose, byte(l.iterator))
+		}
+	}
+}
+
+func (c *compiler) endLoop() {
+	for _, jump := range c.loop.breakJumps {
+		c.patchJump(jump)
+	}
+	c.loop = c.loop.enclosing
+}
+
+func (c *compiler) whileStatement(v parser.While) {
+	c.beginLoop()
+	start := len(c.chunk().Code)
+
+	exitJump := -1
+	if v.Condition != nil {
+		c.expression(v.Condition)
+		exitJump = c.emitJump(OpJumpIfFalse)
+		c.emit(OpPop)
+	}
+
+	c.statement(v.Body)
+
+	for _, jump := range c.loop.continueJumps {
+		c.patchJump(jump)
+	}
+
+	if v.Increment != nil {
+		c.expression(v.Increment)
+		c.emit(OpPop)
+	}
+
+	c.emitLoop(start)
+
+	if exitJump != -1 {
+		c.patchJump(exitJump)
+		c.emit(OpPop)
+	}
+	c.endLoop()
+}
+
+// The iterator lives in a hidden local, and every iteration declares the variable anew,
+// so closures created in the body capture a cell of their own
+func (c *compiler) forEachStatement(v parser.ForEach) {
+	c.at(v.Keyword)
+	c.beginScope()
+
+	c.expression(v.Iterable)
+	c.emit(OpIterate)
+	iterator := c.addLocal(" iterador")
+
+	c.beginLoop()
+	c.loop.iterator = iterator
+	start := len(c.chunk().Code)
+
+	c.emit(OpNext, byte(iterator), 0xFF, 0xFF)
+	exitJump := len(c.chunk().Code) - 2
+
+	c.beginScope()
+	c.at(v.Variable)
+	c.addLocal(v.Variable.Lexeme)
+	c.statement(v.Body)
+	c.endScope()
+
+	for _, jump := range c.loop.continueJumps {
+		c.patchJump(jump)
+	}
+
+	c.emitLoop(start)
+	c.patchJump(exitJump)
+	c.endLoop()
+
+	// Both running out of values and "romper" get here, closing a generator left early
+	c.emit(OpClose, byte(iterator))
+	c.endScope()
+}
+
+// The subject lives in a hidden local that every pattern is matched against
+func (c *compiler) switchStatement(v parser.Switch) {
+	c.at(v.Keyword)
+	c.beginScope()
+
+	c.expression(v.Subject)
+	subject := c.addLocal(" segun")
+
+	var endJumps []int
+
+	for _, sc := range v.Cases {
+		var bodyJumps []int
+
+		for _, pattern := range sc.Patterns {
+			c.emitLocalAccess(OpGetLocal, subject)
+			c.expression(pattern)
+			c.emit(OpMatch)
+
+			nextPattern := c.emitJump(OpJumpIfFalse)
+			c.emit(OpPop)
+			bodyJumps = append(bodyJumps, c.emitJump(OpJump))
+			c.patchJump(nextPattern)
+			c.emit(OpPop)
+		}
+
+		nextCase := c.emitJump(OpJump)
+		for _, jump := range bodyJumps {
+			c.patchJump(jump)
+		}
+
+		c.statement(sc.Body)
+		endJumps = append(endJumps, c.emitJump(OpJump))
+		c.patchJump(nextCase)
+	}
+
+	c.statement(v.Default)
+
+	for _, jump := range endJumps {
+		c.patchJump(jump)
+	}
+
+	c.endScope()
+}
+
+// declareFunctions gives the local functions of a block their slots, empty until each
+// declaration is reached
+func (c *compiler) declareFunctions(stmts []parser.Stmt) {
+	if c.scopeDepth == 0 {
+		return
+	}
+
+	for _, s := range stmts {
+		if v, ok := s.(parser.FnDecl); ok {
+			c.at(v.Name)
+			c.emit(OpNil)
+			slot := c.addLocal(v.Name.Lexeme)
+			c.locals[slot].pending = true
+		}
+	}
+}
+
+// A local function already has its slot when its body is compiled, so it can call itself
+func (c *compiler) functionDeclaration(v parser.FnDecl) {
+	c.at(v.Name)
+
+	if c.scopeDepth == 0 {
+		c.functionBody(v)
+		c.emitShort(OpDefineGlobal, c.makeConstant(v.Name.Lexeme))
+		return
+	}
+
+	slot := c.pendingFunction(v.Name.Lexeme)
+	c.locals[slot].pending = false
+	c.functionBody(v)
+	c.emitLocalAccess(OpSetLocal, slot)
+	c.emit(OpPop)
+}
+
+// pendingFunction finds the slot declareFunctions gave a function, the first one left
+// in the current scope. Functions declared where there is no block, as the only
+// statement of an "si", get theirs now
+func (c *compiler) pendingFunction(name string) int {
+	for i := range c.locals {
+		if c.locals[i].depth == c.scopeDepth && c.locals[i].pending && c.locals[i].name == name {
+			return i
+		}
+	}
+
+	c.emit(OpNil)
+	return c.addLocal(name)
+}
+
+func (c *compiler) functionBody(v parser.FnDecl) {
+	fc := newCompiler(c, v.Name.Lexeme, v.IsGenerator)
+	fc.beginScope()
+
+	// The parser reserves an empty first parameter, which has no place in the VM
+	for _, parameter := range v.Parameters {
+		if parameter.Lexeme == "" {
+			continue
+		}
+		fc.function.Arity++
+		fc.addLocal(parameter.Lexeme)
+	}
+	fc.declareFunctions(v.Body)
+
+	for _, s := range v.Body {
+		fc.statement(s)
+	}
+
+	function := fc.finish()
+
+	c.emitShort(OpClosure, c.makeConstant(function))
+	for _, u := range fc.upvalues {
+		isLocal := byte(0)
+		if u.isLocal {
+			isLocal = 1
+		}
+		c.emit(isLocal, byte(u.index))
+	}
+}
+
+func (c *compiler) expression(expr parser.Expression) {
+	if v, ok := expr.(parser.LiteralExpression); ok {
+		c.literal(v.Value)
+	} else if v, ok := expr.(parser.GroupingExpression); ok {
+		c.expression(v.Expression)
+	} else if v, ok := expr.(parser.UnaryExpression); ok {
+		c.expression(v.Right)
+		c.at(v.Operator)
+		if v.Operator.TokenType == lexer.TokenMinus {
+			c.emit(OpNegate)
+		} else {
+			c.emit(OpNot)
+		}
+	} else if v, ok := expr.(parser.BinaryExpression); ok {
+		c.expression(v.Left)
+		c.expression(v.Right)
+		c.at(v.Operator)
+		c.emit(binaryOperators[v.Operator.TokenType])
+	} else if v, ok := expr.(parser.VariableExpression); ok {
+		c.getVariable(v.Name)
+	} else if v, ok := expr.(parser.AssignmentExpression); ok {
+		c.expression(v.Value)
+		c.setVariable(v.Name)
+	} else if v, ok := expr.(parser.LogicalExpression); ok {
+		c.logical(v)
+	} else if v, ok := expr.(parser.ConditionalExpression); ok {
+		c.conditional(v)
+	} else if v, ok := expr.(parser.RangeExpression); ok {
+		c.expression(v.Start)
+		c.expression(v.End)
+		c.at(v.Operator)
+		if v.Step != nil {
+			c.expression(v.Step)
+			c.emit(OpRangeStep)
+		} else {
+			c.emit(OpRange)
+		}
+	} else if v, ok := expr.(parser.CallExpression); ok {
+		c.call(v, OpCall)
+	} else if v, ok := expr.(parser.SpawnExpression); ok {
+		c.at(v.Keyword)
+		c.call(v.Call, OpSpawn)
+	} else if expr != nil {
+		c.error(fmt.Sprintf("No se puede compilar la expresión %T", expr))
+	}
+}
+
+var binaryOperators = map[int]byte{
+	lexer.TokenEqualEqual:    OpEqual,
+	lexer.TokenNotEqualTo:    OpNotEqual,
+	lexer.TokenGreaterThan:   OpGreater,
+	lexer.TokenGreaterEqual:  OpGreaterEqual,
+	lexer.TokenLessThan:      OpLess,
+	lexer.TokenLessEqual:     OpLessEqual,
+	lexer.TokenPlus:          OpAdd,
+	lexer.TokenMinus:         OpSubtract,
+	lexer.TokenMult:          OpMultiply,
+	lexer.TokenDivision:      OpDivide,
+	lexer.TokenModulo:        OpModulo,
+	lexer.TokenExponentation: OpPower,
+}
+
+func (c *compiler) literal(value interface{}) {
+	if value == nil {
+		c.emit(OpNil)
+	} else if value == true {
+		c.emit(OpTrue)
+	} else if value == false {
+		c.emit(OpFalse)
+	} else {
+		c.emitConstant(value)
+	}
+}
+
+func (c *compiler) logical(v parser.LogicalExpression) {
+	c.expression(v.Left)
+	c.at(v.Operator)
+
+	var endJump int
+
+	if v.Operator.TokenType == lexer.TokenNullCoalescing {
+		endJump = c.emitJump(OpJumpIfNotNil)
+	} else if v.Operator.TokenType == lexer.TokenOr {
+		elseJump := c.emitJump(OpJumpIfFalse)
+		endJump = c.emitJump(OpJump)
+		c.patchJump(elseJump)
+	} else {
+		endJump = c.emitJump(OpJumpIfFalse)
+	}
+
+	c.emit(OpPop)
+	c.expression(v.Right)
+	c.patchJump(endJump)
+}
+
+func (c *compiler) conditional(v parser.ConditionalExpression) {
+	c.expression(v.Condition)
+	c.at(v.Question)
+
+	elseJump := c.emitJump(OpJumpIfFalse)
+	c.emit(OpPop)
+	c.expression(v.ThenBranch)
+
+	endJump := c.emitJump(OpJump)
+	c.patchJump(elseJump)
+	c.emit(OpPop)
+	c.expression(v.ElseBranch)
+
+	c.patchJump(endJump)
+}
+
+// The parser reserves an empty first argument, which has no place in the VM
+func (c *compiler) call(v parser.CallExpression, op byte) {
+	c.expression(v.Callee)
+
+	count := 0
+	for _, argument := range v.Arguments {
+		if argument == nil {
+			continue
+		}
+		c.expression(argument)
+		count++
+	}
+
+	if count > 255 {
+		c.error("No se pueden pasar más de 255 argumentos.")
+	}
+
+	// A tail call leaves the loops it is in, like any other return
+	if op == OpTailCall {
+		c.closeIterators()
+	}
+
+	c.at(v.ClosingParenteses)
+	c.emit(op, byte(count))
+}
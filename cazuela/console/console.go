@@ -0,0 +1,384 @@
+package console
+
+import (
+	"bufio"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/lexer"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+)
+
+/*
+Reads what is typed in the REPL. Input goes on over several lines until it is a complete
+piece of code, so blocks can be written as in a file.
+
+When the standard input is a terminal it is put in raw mode while a line is read, to
+edit it:
+
+	←, →, Ctrl-B, Ctrl-F         move the cursor
+	Home, End, Ctrl-A, Ctrl-E    go to the start or the end of the line
+	Backspace, Delete            delete a character
+	Ctrl-U, Ctrl-K               delete up to the start or the end of the line
+	↑, ↓, Ctrl-P, Ctrl-N         go through the lines typed before
+	Ctrl-C                       discard what was typed, or leave if nothing was
+	Ctrl-D                       leave, on an empty line
+
+Lines are kept in a history file, so they are still there in the next session. When the
+input is not a terminal, as when a program is piped in, lines are read as they come.
+*/
+
+// ErrInterrupted is given by ReadInput when Ctrl-C is pressed with nothing typed
+var ErrInterrupted = errors.New("interrumpido")
+
+// How many lines the history keeps
+const maxHistory = 1000
+
+// A Console reads input from the standard input, writing prompts and echoes to the standard output
+type Console struct {
+	// Prompt is shown before the first line of an input, Continuation before the rest
+	Prompt       string
+	Continuation string
+
+	in          *bufio.Reader
+	out         io.Writer
+	fd          int
+	terminal    bool
+	history     []string
+	historyPath string
+}
+
+// New creates a console keeping its history in historyPath, or only for this session if it is empty
+func New(historyPath string) *Console {
+	c := &Console{
+		Prompt:       "> ",
+		Continuation: "... ",
+		in:           bufio.NewReader(os.Stdin),
+		out:          os.Stdout,
+		fd:           int(os.Stdin.Fd()),
+		historyPath:  historyPath,
+	}
+	c.terminal = isTerminal(c.fd)
+
+	if c.terminal {
+		c.loadHistory()
+	}
+	return c
+}
+
+// ReadInput reads lines until they make a complete piece of code, which it gives
+// without its last newline. It gives io.EOF once the input is over, and ErrInterrupted
+// if Ctrl-C is pressed with nothing typed
+func (c *Console) ReadInput() (string, error) {
+	var lines []string
+
+	for {
+		prompt := c.Prompt
+		if len(lines) > 0 {
+			prompt = c.Continuation
+		}
+
+		line, err := c.readLine(prompt)
+
+		if err == ErrInterrupted {
+			if len(lines) == 0 && line == "" {
+				return "", err
+			}
+			lines = nil
+			continue
+		}
+
+		if err != nil {
+			// What was typed before the input ended still runs, even if it is incomplete
+			if err == io.EOF && len(lines) > 0 {
+				return strings.Join(lines, "\n"), nil
+			}
+			return "", err
+		}
+
+		lines = append(lines, line)
+		source := strings.Join(lines, "\n")
+		if IsComplete(source) {
+			return source, nil
+		}
+	}
+}
+
+// IsComplete tells whether source can be run as it is, or more lines are needed: when
+// a string isn't closed, or there are more braces or parentheses opened than closed.
+// Other errors are left for when it runs
+func IsComplete(source string) bool {
+	var tokens []lexer.Token
+	diagnostics := errorHandler.Collect(func() {
+		tokens = lexer.GetTokens(source)
+	})
+
+	for _, d := range diagnostics {
+		if d.Code == errorHandler.CodeUnexpectedEOF {
+			return false
+		}
+	}
+
+	depth := 0
+	for _, t := range tokens {
+		switch t.TokenType {
+		case lexer.TokenLeftBrace, lexer.TokenLeftParentheses:
+			depth++
+		case lexer.TokenRightBrace, lexer.TokenRightParenteses:
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// readLine reads a single line, editing it if the input is a terminal. On Ctrl-C it
+// gives what had been typed along with ErrInterrupted
+func (c *Console) readLine(prompt string) (string, error) {
+	if !c.terminal {
+		fmt.Fprint(c.out, prompt)
+
+		line, err := c.in.ReadString('\n')
+		if err == io.EOF && line == "" {
+			fmt.Fprintln(c.out)
+			return "", io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	state, err := makeRaw(c.fd)
+	if err != nil {
+		c.terminal = false
+		return c.readLine(prompt)
+	}
+	defer restore(c.fd, state)
+
+	line, err := c.edit(prompt)
+	if err == nil {
+		c.addHistory(line)
+	}
+	return line, err
+}
+
+// A lineEditor is a line being edited, along with where it is in the history
+type lineEditor struct {
+	buffer  []rune
+	cursor  int
+	entry   int
+	pending []rune
+}
+
+// edit reads keys until Enter, Ctrl-C or Ctrl-D, redrawing the line after each one
+func (c *Console) edit(prompt string) (string, error) {
+	e := &lineEditor{entry: len(c.history)}
+	c.draw(prompt, e)
+
+	for {
+		r, _, err := c.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(c.out, "\r\n")
+			return string(e.buffer), nil
+		case ctrl('C'):
+			fmt.Fprint(c.out, "^C\r\n")
+			return string(e.buffer), ErrInterrupted
+		case ctrl('D'):
+			if len(e.buffer) == 0 {
+				fmt.Fprint(c.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete()
+		case 127, ctrl('H'):
+			if e.cursor > 0 {
+				e.cursor--
+				e.delete()
+			}
+		case ctrl('A'):
+			e.cursor = 0
+		case ctrl('E'):
+			e.cursor = len(e.buffer)
+		case ctrl('B'):
+			e.left()
+		case ctrl('F'):
+			e.right()
+		case ctrl('U'):
+			e.buffer = e.buffer[e.cursor:]
+			e.cursor = 0
+		case ctrl('K'):
+			e.buffer = e.buffer[:e.cursor]
+		case ctrl('P'):
+			e.previous(c.history)
+		case ctrl('N'):
+			e.next(c.history)
+		case 27:
+			c.escape(e)
+		default:
+			if unicode.IsPrint(r) {
+				e.insert(r)
+			}
+		}
+
+		c.draw(prompt, e)
+	}
+}
+
+// escape handles the sequences terminals send for arrows and the like, such as ESC [ A
+func (c *Console) escape(e *lineEditor) {
+	r, _, err := c.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+
+	key, _, err := c.in.ReadRune()
+	if err != nil {
+		return
+	}
+
+	// Sequences such as ESC [ 3 ~ are ended by a tilde
+	if key >= '0' && key <= '9' {
+		for {
+			r, _, err := c.in.ReadRune()
+			if err != nil || r == '~' {
+				break
+			}
+		}
+	}
+
+	switch key {
+	case 'A':
+		e.previous(c.history)
+	case 'B':
+		e.next(c.history)
+	case 'C':
+		e.right()
+	case 'D':
+		e.left()
+	case 'H', '1', '7':
+		e.cursor = 0
+	case 'F', '4', '8':
+		e.cursor = len(e.buffer)
+	case '3':
+		e.delete()
+	}
+}
+
+// draw writes the line again, assuming it fits in the width of the terminal
+func (c *Console) draw(prompt string, e *lineEditor) {
+	fmt.Fprintf(c.out, "\r%v%v\x1b[K", prompt, string(e.buffer))
+	if back := len(e.buffer) - e.cursor; back > 0 {
+		fmt.Fprintf(c.out, "\x1b[%dD", back)
+	}
+}
+
+func ctrl(key rune) rune {
+	return key & 0x1f
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.buffer = append(e.buffer[:e.cursor], append([]rune{r}, e.buffer[e.cursor:]...)...)
+	e.cursor++
+}
+
+// delete removes the character under the cursor
+func (e *lineEditor) delete() {
+	if e.cursor < len(e.buffer) {
+		e.buffer = append(e.buffer[:e.cursor], e.buffer[e.cursor+1:]...)
+	}
+}
+
+func (e *lineEditor) left() {
+	if e.cursor > 0 {
+		e.cursor--
+	}
+}
+
+func (e *lineEditor) right() {
+	if e.cursor < len(e.buffer) {
+		e.cursor++
+	}
+}
+
+// previous replaces the line with the one before it in the history, keeping what was
+// being typed to come back to it
+func (e *lineEditor) previous(history []string) {
+	if e.entry == 0 {
+		return
+	}
+	if e.entry == len(history) {
+		e.pending = e.buffer
+	}
+
+	e.entry--
+	e.buffer = []rune(history[e.entry])
+	e.cursor = len(e.buffer)
+}
+
+func (e *lineEditor) next(history []string) {
+	if e.entry == len(history) {
+		return
+	}
+
+	e.entry++
+	if e.entry == len(history) {
+		e.buffer = e.pending
+	} else {
+		e.buffer = []rune(history[e.entry])
+	}
+	e.cursor = len(e.buffer)
+}
+
+// The history is kept as well as it can be: a file that can't be read or written only
+// means lines aren't remembered between sessions
+
+func (c *Console) loadHistory() {
+	if c.historyPath == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(c.historyPath)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			c.history = append(c.history, line)
+		}
+	}
+	if len(c.history) > maxHistory {
+		c.history = c.history[len(c.history)-maxHistory:]
+	}
+}
+
+// addHistory remembers a line, unless it is blank or the same as the last one
+func (c *Console) addHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(c.history) > 0 && c.history[len(c.history)-1] == line) {
+		return
+	}
+
+	c.history = append(c.history, line)
+	if len(c.history) > maxHistory {
+		c.history = c.history[1:]
+	}
+
+	if c.historyPath == "" {
+		return
+	}
+
+	file, err := os.OpenFile(c.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
@@ -0,0 +1,6 @@
+package console
+
+import "syscall"
+
+const getTermios = syscall.TIOCGETA
+const setTermios = syscall.TIOCSETA
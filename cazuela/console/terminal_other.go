@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package console
+
+import "errors"
+
+// Elsewhere lines are read as they come, without editing them
+type terminalState struct{}
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (*terminalState, error) {
+	return nil, errors.New("no se puede editar la línea en este sistema")
+}
+
+func restore(fd int, state *terminalState) {}
@@ -0,0 +1,51 @@
+//go:build linux || darwin
+
+package console
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// terminalState is how the terminal was before going raw, to leave it as it was
+type terminalState struct {
+	termios syscall.Termios
+}
+
+func isTerminal(fd int) bool {
+	var termios syscall.Termios
+	return ioctl(fd, getTermios, &termios) == nil
+}
+
+// makeRaw makes the terminal hand over every key as it is pressed, without echoing it
+// nor turning Ctrl-C into a signal
+func makeRaw(fd int) (*terminalState, error) {
+	var original syscall.Termios
+	if err := ioctl(fd, getTermios, &original); err != nil {
+		return nil, err
+	}
+
+	raw := original
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(fd, setTermios, &raw); err != nil {
+		return nil, err
+	}
+	return &terminalState{original}, nil
+}
+
+func restore(fd int, state *terminalState) {
+	ioctl(fd, setTermios, &state.termios)
+}
+
+func ioctl(fd int, request uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+package dap
+
+import (
+	"bufio"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/interpreter"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"clase-mates-computacionales/cazuela/values"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+)
+
+/*
+A Debug Adapter Protocol server, so editors can debug Cazuela programs. It drives the
+hook points of the interpreter the same way the terminal debugger does.
+
+A session goes: initialize, launch with the path of the program, setBreakpoints,
+configurationDone, and from then on the program runs, stopping at breakpoints and
+steps. While it is stopped the client may ask for the stack, scopes, variables and
+evaluate expressions. Everything the program writes, errors included, is sent as
+output events, since the standard output belongs to the protocol.
+
+Cazuela programs live in a single file, so breakpoints are kept by line alone. Tasks
+are not told apart: the program shows up as a single thread, stopped in whichever task
+reached a breakpoint. Only the innermost frame has local scopes, the interpreter
+doesn't keep the environments of the calls it is in the middle of.
+*/
+
+const threadID = 1
+
+const (
+	modeStep = iota
+	modeOver
+	modeOut
+	modeContinue
+)
+
+// A Server talks to one client through in and out, until it disconnects
+type Server struct {
+	in    *bufio.Reader
+	out   io.Writer
+	write sync.Mutex
+	seq   int
+
+	program     string
+	source      source
+	statements  []parser.Stmt
+	stopOnEntry bool
+	cancel      context.CancelFunc
+	done        chan struct{}
+
+	// What the hook shares with the requests, guarded by lock
+	lock        sync.Mutex
+	breakpoints map[int]bool
+	mode        int
+	from        *interpreter.State
+	stopped     *interpreter.State
+	scopes      [][]interpreter.Scope
+	resume      chan int
+
+	// Only one thread is stopped at a time
+	hook sync.Mutex
+}
+
+// NewServer creates a server reading requests from in and writing to out
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:          bufio.NewReader(in),
+		out:         out,
+		breakpoints: make(map[int]bool),
+		resume:      make(chan int),
+		done:        make(chan struct{}),
+	}
+}
+
+// Serve answers requests until the client disconnects or goes away
+func (s *Server) Serve() error {
+	for {
+		request, err := readMessage(s.in)
+		if err == io.EOF {
+			s.stop()
+			return nil
+		}
+		if err != nil {
+			s.stop()
+			return err
+		}
+
+		if request.Type != "request" {
+			continue
+		}
+
+		if !s.handle(request) {
+			return nil
+		}
+	}
+}
+
+// handle answers a request, telling whether the session goes on
+func (s *Server) handle(request message) bool {
+	switch request.Command {
+	case "initialize":
+		s.respond(request, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+		})
+	case "launch":
+		s.launch(request)
+	case "setBreakpoints":
+		s.setBreakpoints(request)
+	case "setExceptionBreakpoints":
+		s.respond(request, nil)
+	case "configurationDone":
+		s.respond(request, nil)
+		go s.run()
+	case "threads":
+		s.respond(request, map[string]interface{}{
+			"threads": []thread{{threadID, "Cazuela"}},
+		})
+	case "stackTrace":
+		s.stackTrace(request)
+	case "scopes":
+		s.scopesOf(request)
+	case "variables":
+		s.variables(request)
+	case "evaluate":
+		s.evaluate(request)
+	case "continue":
+		s.respond(request, map[string]interface{}{"allThreadsContinued": true})
+		s.proceed(modeContinue)
+	case "next":
+		s.respond(request, nil)
+		s.proceed(modeOver)
+	case "stepIn":
+		s.respond(request, nil)
+		s.proceed(modeStep)
+	case "stepOut":
+		s.respond(request, nil)
+		s.proceed(modeOut)
+	case "pause":
+		s.lock.Lock()
+		s.mode = modeStep
+		s.lock.Unlock()
+		s.respond(request, nil)
+	case "disconnect", "terminate":
+		s.stop()
+		s.respond(request, nil)
+		return request.Command != "disconnect"
+	default:
+		s.fail(request, fmt.Sprintf("Comando no soportado: %v", request.Command))
+	}
+
+	return true
+}
+
+// launch loads the program, which starts running once the client is done configuring
+func (s *Server) launch(request message) {
+	var arguments launchArguments
+	if err := json.Unmarshal(request.Arguments, &arguments); err != nil || arguments.Program == "" {
+		s.fail(request, "Se esperaba la ruta del programa en 'program'")
+		return
+	}
+
+	data, err := ioutil.ReadFile(arguments.Program)
+	if err != nil {
+		s.fail(request, fmt.Sprintf("No se pudo leer %v: %v", arguments.Program, err))
+		return
+	}
+
+	// Errors must not end the session, and both they and the program output go to the client
+	errorHandler.IgnoreFatals = true
+	errorHandler.Output = outputWriter{s}
+	interpreter.Output = outputWriter{s}
+
+	errorHandler.HasFatalled = false
+	tokens := lexer.GetTokens(string(data))
+	if !errorHandler.HasFatalled {
+		s.statements = parser.Parse(tokens)
+	}
+	if errorHandler.HasFatalled {
+		s.fail(request, "El programa tiene errores")
+		return
+	}
+
+	s.program = arguments.Program
+	s.source = source{Name: filepath.Base(arguments.Program), Path: arguments.Program}
+	s.stopOnEntry = arguments.StopOnEntry
+	if !arguments.NoDebug {
+		interpreter.DebugHook = s.onStatement
+	}
+
+	s.mode = modeContinue
+	if s.stopOnEntry {
+		s.mode = modeStep
+	}
+
+	s.respond(request, nil)
+	s.send(message{Type: "event", Event: "initialized"})
+}
+
+// run runs the program, telling the client once it is over
+func (s *Server) run() {
+	if s.program == "" {
+		return
+	}
+
+	interpreter.InitEnv()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.lock.Lock()
+	s.cancel = cancel
+	s.lock.Unlock()
+
+	errorHandler.HasExited = false
+	interpreter.Interpret(ctx, s.statements)
+	cancel()
+
+	s.send(message{Type: "event", Event: "exited", Body: map[string]interface{}{"exitCode": errorHandler.ExitCode()}})
+	s.send(message{Type: "event", Event: "terminated"})
+	close(s.done)
+}
+
+// stop cancels the program, letting a stopped thread go so it can notice
+func (s *Server) stop() {
+	s.lock.Lock()
+	cancel, stopped := s.cancel, s.stopped
+	s.breakpoints = make(map[int]bool)
+	s.mode = modeContinue
+	s.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	if stopped != nil {
+		s.resume <- modeContinue
+	}
+	<-s.done
+}
+
+func (s *Server) setBreakpoints(request message) {
+	var arguments setBreakpointsArguments
+	if err := json.Unmarshal(request.Arguments, &arguments); err != nil {
+		s.fail(request, "Argumentos inválidos")
+		return
+	}
+
+	verified := make([]breakpoint, len(arguments.Breakpoints))
+
+	s.lock.Lock()
+	s.breakpoints = make(map[int]bool)
+	for i, b := range arguments.Breakpoints {
+		s.breakpoints[b.Line] = true
+		verified[i] = breakpoint{true, b.Line}
+	}
+	s.lock.Unlock()
+
+	s.respond(request, map[string]interface{}{"breakpoints": verified})
+}
+
+// onStatement is the interpreter hook, it stops the thread until the client lets it go
+func (s *Server) onStatement(state *interpreter.State) {
+	s.hook.Lock()
+	defer s.hook.Unlock()
+
+	s.lock.Lock()
+	reason := s.stopReason(state)
+	if reason == "" {
+		s.lock.Unlock()
+		return
+	}
+	s.stopped = state
+	s.scopes = nil
+	s.lock.Unlock()
+
+	s.send(message{Type: "event", Event: "stopped", Body: map[string]interface{}{
+		"reason":            reason,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	}})
+
+	mode := <-s.resume
+
+	s.lock.Lock()
+	s.mode = mode
+	s.from = state
+	s.stopped = nil
+	s.lock.Unlock()
+}
+
+// stopReason tells why the thread has to stop before the statement, "" if it doesn't
+func (s *Server) stopReason(state *interpreter.State) string {
+	if s.breakpoints[state.Line()] {
+		return "breakpoint"
+	}
+
+	switch s.mode {
+	case modeStep:
+		if s.from == nil {
+			return "entry"
+		}
+		return "step"
+	case modeOver:
+		if state.SameThread(s.from) && state.Depth() <= s.from.Depth() {
+			return "step"
+		}
+	case modeOut:
+		if state.SameThread(s.from) && state.Depth() < s.from.Depth() {
+			return "step"
+		}
+	}
+
+	return ""
+}
+
+// proceed lets the stopped thread go on, in the given mode
+func (s *Server) proceed(mode int) {
+	s.lock.Lock()
+	stopped := s.stopped != nil
+	s.lock.Unlock()
+
+	if stopped {
+		s.resume <- mode
+	}
+}
+
+// current is the state of the stopped thread, failing the request when nothing is stopped
+func (s *Server) current(request message) *interpreter.State {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped == nil {
+		s.fail(request, "El programa no está detenido")
+	}
+	return s.stopped
+}
+
+func (s *Server) stackTrace(request message) {
+	state := s.current(request)
+	if state == nil {
+		return
+	}
+
+	frames := state.Frames()
+	stack := make([]stackFrame, len(frames))
+	for i, frame := range frames {
+		stack[i] = stackFrame{ID: i, Name: frame.Name, Source: s.source, Line: frame.Line, Column: 1}
+	}
+
+	s.respond(request, map[string]interface{}{"stackFrames": stack, "totalFrames": len(stack)})
+}
+
+// scopesOf lists the scopes of a frame. Each one gets a reference for its variables,
+// valid until the thread goes on
+func (s *Server) scopesOf(request message) {
+	var arguments scopesArguments
+	json.Unmarshal(request.Arguments, &arguments)
+
+	state := s.current(request)
+	if state == nil {
+		return
+	}
+
+	all := state.Scopes()
+	visible := all
+	if arguments.FrameID > 0 {
+		visible = all[len(all)-1:]
+	}
+
+	result := make([]scope, len(visible))
+
+	s.lock.Lock()
+	for i, sc := range visible {
+		s.scopes = append(s.scopes, []interpreter.Scope{sc})
+		name := "Locales"
+		if sc.Global {
+			name = "Globales"
+		} else if i > 0 {
+			name = fmt.Sprintf("Ámbito %d", i)
+		}
+		result[i] = scope{name, len(s.scopes), sc.Global}
+	}
+	s.lock.Unlock()
+
+	s.respond(request, map[string]interface{}{"scopes": result})
+}
+
+func (s *Server) variables(request message) {
+	var arguments variablesArguments
+	json.Unmarshal(request.Arguments, &arguments)
+
+	s.lock.Lock()
+	reference := arguments.VariablesReference
+	if reference < 1 || reference > len(s.scopes) {
+		s.lock.Unlock()
+		s.fail(request, "Referencia de variables inválida")
+		return
+	}
+	sc := s.scopes[reference-1][0]
+	s.lock.Unlock()
+
+	result := []variable{}
+	for i, name := range sc.Names {
+		value := sc.Values[i]
+		if _, isNative := value.(*values.Native); isNative || name == "" {
+			continue
+		}
+		result = append(result, variable{Name: name, Value: fmt.Sprintf("%v", value), Type: values.TypeName(value)})
+	}
+
+	s.respond(request, map[string]interface{}{"variables": result})
+}
+
+func (s *Server) evaluate(request message) {
+	var arguments evaluateArguments
+	json.Unmarshal(request.Arguments, &arguments)
+
+	state := s.current(request)
+	if state == nil {
+		return
+	}
+
+	// Evaluate reports its errors through errorHandler, which ends up in an output event
+	value, ok := state.Evaluate(arguments.Expression)
+	if !ok {
+		s.fail(request, fmt.Sprintf("No se pudo evaluar %v", arguments.Expression))
+		return
+	}
+
+	s.respond(request, map[string]interface{}{"result": fmt.Sprintf("%v", value), "variablesReference": 0})
+}
+
+func (s *Server) respond(request message, body interface{}) {
+	success := true
+	s.send(message{Type: "response", RequestSeq: request.Seq, Command: request.Command, Success: &success, Body: body})
+}
+
+func (s *Server) fail(request message, reason string) {
+	success := false
+	s.send(message{Type: "response", RequestSeq: request.Seq, Command: request.Command, Success: &success, Message: reason})
+}
+
+// send numbers a message and writes it, from whichever goroutine has something to say
+func (s *Server) send(msg message) {
+	s.write.Lock()
+	defer s.write.Unlock()
+
+	s.seq++
+	msg.Seq = s.seq
+	writeMessage(s.out, msg)
+}
+
+// An outputWriter turns what the program writes into output events
+type outputWriter struct {
+	s *Server
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.s.send(message{Type: "event", Event: "output", Body: map[string]interface{}{
+		"category": "stdout",
+		"output":   string(p),
+	}})
+	return len(p), nil
+}
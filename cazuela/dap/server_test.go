@@ -0,0 +1,329 @@
+package dap
+
+import (
+	"bufio"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/interpreter"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const program = `fn doble(n) {
+    var r = n * 2;
+    sazonar r;
+}
+
+var x = 20;
+var resultado = doble(x);
+servir resultado;
+servir x + 1;
+`
+
+// A client drives a server through pipes, as an editor would through stdio
+type client struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan message
+	seq      int
+
+	// The events received while waiting for something else
+	events []message
+	output strings.Builder
+}
+
+func newClient(t *testing.T) *client {
+	requests, requestWriter := io.Pipe()
+	responseReader, responses := io.Pipe()
+
+	c := &client{t: t, in: requestWriter, messages: make(chan message, 100)}
+
+	server := NewServer(requests, responses)
+	go func() {
+		server.Serve()
+		responses.Close()
+	}()
+
+	go func() {
+		r := bufio.NewReader(responseReader)
+		for {
+			msg, err := readMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+
+	return c
+}
+
+// next gives the next message from the server, failing if it takes too long
+func (c *client) next() message {
+	c.t.Helper()
+
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("el servidor cerró la conexión")
+		}
+		if msg.Type == "event" && msg.Event == "output" {
+			var body struct{ Output string }
+			decode(c.t, msg, &body)
+			c.output.WriteString(body.Output)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("el servidor no respondió a tiempo")
+		return message{}
+	}
+}
+
+// request sends a request and waits for its response, decoding its body into body
+func (c *client) request(command string, arguments interface{}, body interface{}) message {
+	c.t.Helper()
+
+	response := c.send(command, arguments)
+	if response.Success == nil || !*response.Success {
+		c.t.Fatalf("%v falló: %v\n%v", command, response.Message, c.output.String())
+	}
+	if body != nil {
+		decode(c.t, response, body)
+	}
+	return response
+}
+
+// send sends a request and waits for its response, whether it succeeded or not
+func (c *client) send(command string, arguments interface{}) message {
+	c.t.Helper()
+
+	c.seq++
+	msg := message{Seq: c.seq, Type: "request", Command: command}
+	if arguments != nil {
+		data, err := json.Marshal(arguments)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		msg.Arguments = data
+	}
+	if err := writeMessage(c.in, msg); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		response := c.next()
+		if response.Type == "event" {
+			c.events = append(c.events, response)
+			continue
+		}
+		if response.RequestSeq != c.seq || response.Command != command {
+			c.t.Fatalf("se esperaba la respuesta a %v, llegó %+v", command, response)
+		}
+		return response
+	}
+}
+
+// event waits for an event, which may have arrived already, decoding its body into body
+func (c *client) event(name string, body interface{}) message {
+	c.t.Helper()
+
+	for i, msg := range c.events {
+		if msg.Event == name {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			if body != nil {
+				decode(c.t, msg, body)
+			}
+			return msg
+		}
+	}
+
+	for {
+		msg := c.next()
+		if msg.Type == "event" && msg.Event == name {
+			if body != nil {
+				decode(c.t, msg, body)
+			}
+			return msg
+		}
+		if msg.Type == "event" {
+			continue
+		}
+		c.t.Fatalf("se esperaba el evento %v, llegó %+v", name, msg)
+	}
+}
+
+func decode(t *testing.T, msg message, body interface{}) {
+	t.Helper()
+
+	data, err := json.Marshal(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type stoppedBody struct {
+	Reason   string `json:"reason"`
+	ThreadID int    `json:"threadId"`
+}
+
+type stackTraceBody struct {
+	StackFrames []stackFrame `json:"stackFrames"`
+}
+
+// topLine is the line the innermost frame is stopped at
+func (c *client) topLine() int {
+	c.t.Helper()
+
+	var body stackTraceBody
+	c.request("stackTrace", map[string]interface{}{"threadId": threadID}, &body)
+	if len(body.StackFrames) == 0 {
+		c.t.Fatal("stackTrace no dio ningún marco")
+	}
+	return body.StackFrames[0].Line
+}
+
+func TestSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doble.caz")
+	if err := ioutil.WriteFile(path, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		errorHandler.IgnoreFatals = false
+		errorHandler.Output = os.Stdout
+		interpreter.Output = os.Stdout
+		interpreter.DebugHook = nil
+	}()
+
+	c := newClient(t)
+
+	var capabilities map[string]bool
+	c.request("initialize", map[string]interface{}{"adapterID": "cazuela"}, &capabilities)
+	if !capabilities["supportsConfigurationDoneRequest"] {
+		t.Errorf("initialize no anunció configurationDone: %v", capabilities)
+	}
+
+	c.request("launch", launchArguments{Program: path}, nil)
+	c.event("initialized", nil)
+
+	var breakpoints struct {
+		Breakpoints []breakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: path},
+		Breakpoints: []sourceBreakpoint{{Line: 2}},
+	}, &breakpoints)
+	if len(breakpoints.Breakpoints) != 1 || !breakpoints.Breakpoints[0].Verified || breakpoints.Breakpoints[0].Line != 2 {
+		t.Errorf("setBreakpoints dio %+v", breakpoints.Breakpoints)
+	}
+
+	c.request("configurationDone", nil, nil)
+
+	var stopped stoppedBody
+	c.event("stopped", &stopped)
+	if stopped.Reason != "breakpoint" || stopped.ThreadID != threadID {
+		t.Errorf("se esperaba detenerse en el punto de interrupción, se obtuvo %+v", stopped)
+	}
+
+	var stack stackTraceBody
+	c.request("stackTrace", map[string]interface{}{"threadId": threadID}, &stack)
+	if len(stack.StackFrames) != 2 {
+		t.Fatalf("se esperaban 2 marcos, se obtuvieron %+v", stack.StackFrames)
+	}
+	if top := stack.StackFrames[0]; top.Name != "doble" || top.Line != 2 || top.Source.Path != path {
+		t.Errorf("marco interno: %+v", top)
+	}
+	if bottom := stack.StackFrames[1]; bottom.Line != 7 {
+		t.Errorf("marco externo: %+v", bottom)
+	}
+
+	var scopes struct {
+		Scopes []scope `json:"scopes"`
+	}
+	c.request("scopes", scopesArguments{FrameID: 0}, &scopes)
+	if len(scopes.Scopes) < 2 || scopes.Scopes[0].Name != "Locales" || scopes.Scopes[len(scopes.Scopes)-1].Name != "Globales" {
+		t.Fatalf("scopes dio %+v", scopes.Scopes)
+	}
+
+	var locals struct {
+		Variables []variable `json:"variables"`
+	}
+	c.request("variables", variablesArguments{VariablesReference: scopes.Scopes[0].VariablesReference}, &locals)
+	if len(locals.Variables) != 1 || locals.Variables[0].Name != "n" || locals.Variables[0].Value != "20" || locals.Variables[0].Type != "número" {
+		t.Errorf("variables locales: %+v", locals.Variables)
+	}
+
+	var globals struct {
+		Variables []variable `json:"variables"`
+	}
+	c.request("variables", variablesArguments{VariablesReference: scopes.Scopes[len(scopes.Scopes)-1].VariablesReference}, &globals)
+	found := false
+	for _, v := range globals.Variables {
+		if v.Name == "x" && v.Value == "20" {
+			found = true
+		}
+		if v.Type == "nativa" {
+			t.Errorf("las nativas no se muestran: %+v", v)
+		}
+	}
+	if !found {
+		t.Errorf("falta x entre las globales: %+v", globals.Variables)
+	}
+
+	var evaluated struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", evaluateArguments{Expression: "n + x + 1"}, &evaluated)
+	if evaluated.Result != "41" {
+		t.Errorf("evaluate dio %q", evaluated.Result)
+	}
+
+	// Errors are reported at the line the program is paused at
+	for _, expression := range []string{"n + noexiste", "n +", "n # 2"} {
+		c.output.Reset()
+		response := c.send("evaluate", evaluateArguments{Expression: expression})
+		if response.Success == nil || *response.Success {
+			t.Errorf("evaluate de %q no falló", expression)
+		}
+		// The error is written before the response is sent
+		if output := c.output.String(); !strings.HasPrefix(output, "[2] Error") || strings.Contains(output, "[1]") {
+			t.Errorf("evaluate de %q no informó del error en la línea 2: %q", expression, output)
+		}
+	}
+	c.output.Reset()
+
+	c.request("next", map[string]interface{}{"threadId": threadID}, nil)
+	c.event("stopped", &stopped)
+	if stopped.Reason != "step" {
+		t.Errorf("next se detuvo por %q", stopped.Reason)
+	}
+	if line := c.topLine(); line != 3 {
+		t.Errorf("next se detuvo en la línea %d, no en la 3", line)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": threadID}, nil)
+
+	var exited struct {
+		ExitCode int `json:"exitCode"`
+	}
+	c.event("exited", &exited)
+	if exited.ExitCode != errorHandler.CodeAllGood {
+		t.Errorf("el programa terminó con %d", exited.ExitCode)
+	}
+	c.event("terminated", nil)
+
+	if got := c.output.String(); got != "40\n21\n" {
+		t.Errorf("el programa sirvió %q", got)
+	}
+
+	c.request("disconnect", nil, nil)
+}
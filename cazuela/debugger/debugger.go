@@ -0,0 +1,249 @@
+package debugger
+
+import (
+	"bufio"
+	"clase-mates-computacionales/cazuela/interpreter"
+	"clase-mates-computacionales/cazuela/values"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+A terminal debugger for Cazuela programs, built on the hook points of the
+interpreter. It pauses before the first statement and then whenever a breakpoint
+or a step says so, reading commands until told to go on:
+
+	paso (p)             runs until the next statement, entering calls
+	siguiente (n)        runs until the next statement of this function or an outer one
+	fuera (f)            runs until the current function returns
+	continuar (c)        runs until a breakpoint
+	punto (b) N          sets a breakpoint at line N
+	quitar (d) N         removes the breakpoint at line N
+	locales (l)          shows the variables in scope
+	vigilar (w) EXPR     shows EXPR every time the program pauses
+	imprimir (i) EXPR    shows EXPR once
+	pila (bt)            shows the calls being run
+	ayuda (h)            lists the commands
+	salir (q)            ends the program
+
+An empty line repeats the previous command.
+*/
+
+const (
+	modeStep = iota
+	modeOver
+	modeOut
+	modeContinue
+)
+
+// A Debugger decides when the program pauses and talks to the user while it is paused
+type Debugger struct {
+	in          *bufio.Scanner
+	out         io.Writer
+	source      []string
+	breakpoints map[int]bool
+	watches     []string
+	mode        int
+	from        *interpreter.State
+	last        string
+	lock        sync.Mutex
+}
+
+// New creates a debugger for the program in source, talking through in and out
+func New(source string, in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:          bufio.NewScanner(in),
+		out:         out,
+		source:      strings.Split(source, "\n"),
+		breakpoints: make(map[int]bool),
+		mode:        modeStep,
+	}
+}
+
+// Hook is meant to be set as interpreter.DebugHook
+func (d *Debugger) Hook(s *interpreter.State) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.shouldPause(s) {
+		return
+	}
+
+	d.from = s
+	d.showLocation(s)
+	d.showWatches(s)
+
+	for {
+		fmt.Fprint(d.out, "(depurar) ")
+		if !d.in.Scan() {
+			// Without anyone to give commands, the program runs to the end
+			d.mode = modeContinue
+			return
+		}
+
+		command := strings.TrimSpace(d.in.Text())
+		if command == "" {
+			command = d.last
+		}
+		d.last = command
+
+		if d.run(s, command) {
+			return
+		}
+	}
+}
+
+// Finish is called once the program is over
+func (d *Debugger) Finish() {
+	fmt.Fprintln(d.out, "El programa terminó.")
+}
+
+func (d *Debugger) shouldPause(s *interpreter.State) bool {
+	if d.breakpoints[s.Line()] {
+		return true
+	}
+
+	switch d.mode {
+	case modeStep:
+		return true
+	case modeOver:
+		return s.SameThread(d.from) && s.Depth() <= d.from.Depth()
+	case modeOut:
+		return s.SameThread(d.from) && s.Depth() < d.from.Depth()
+	}
+
+	return false
+}
+
+// run carries out a command, telling whether the program goes on
+func (d *Debugger) run(s *interpreter.State, command string) bool {
+	name, argument := command, ""
+	if i := strings.IndexByte(command, ' '); i >= 0 {
+		name, argument = command[:i], strings.TrimSpace(command[i+1:])
+	}
+
+	switch name {
+	case "paso", "p":
+		d.mode = modeStep
+		return true
+	case "siguiente", "n":
+		d.mode = modeOver
+		return true
+	case "fuera", "f":
+		d.mode = modeOut
+		return true
+	case "continuar", "c":
+		d.mode = modeContinue
+		return true
+	case "punto", "b":
+		if line, ok := d.lineArgument(argument); ok {
+			d.breakpoints[line] = true
+			fmt.Fprintf(d.out, "Punto de interrupción en la línea %d\n", line)
+		}
+	case "quitar", "d":
+		if line, ok := d.lineArgument(argument); ok {
+			delete(d.breakpoints, line)
+		}
+	case "locales", "l":
+		d.showScopes(s)
+	case "vigilar", "w":
+		d.watches = append(d.watches, argument)
+		d.showValue(s, argument)
+	case "imprimir", "i":
+		d.showValue(s, argument)
+	case "pila", "bt":
+		for _, frame := range s.Frames() {
+			fmt.Fprintf(d.out, "  en %v (línea %d)\n", frame.Name, frame.Line)
+		}
+	case "ayuda", "h":
+		d.showHelp()
+	case "salir", "q":
+		os.Exit(0)
+	default:
+		fmt.Fprintf(d.out, "Comando desconocido: %v, escribe 'ayuda' para ver los comandos\n", name)
+	}
+
+	return false
+}
+
+func (d *Debugger) lineArgument(argument string) (int, bool) {
+	line, err := strconv.Atoi(argument)
+	if err != nil || line < 1 {
+		fmt.Fprintf(d.out, "Se esperaba un número de línea, se obtuvo '%v'\n", argument)
+		return 0, false
+	}
+	return line, true
+}
+
+func (d *Debugger) showLocation(s *interpreter.State) {
+	text := ""
+	if s.Line() <= len(d.source) {
+		text = strings.TrimSpace(d.source[s.Line()-1])
+	}
+
+	fmt.Fprintf(d.out, "→ %v, línea %d: %v\n", s.Function(), s.Line(), text)
+}
+
+func (d *Debugger) showWatches(s *interpreter.State) {
+	for _, watch := range d.watches {
+		d.showValue(s, watch)
+	}
+}
+
+func (d *Debugger) showValue(s *interpreter.State, expression string) {
+	if value, ok := s.Evaluate(expression); ok {
+		fmt.Fprintf(d.out, "  %v = %v\n", expression, value)
+	}
+}
+
+// showScopes lists the local scopes from the innermost out, then the globals the program declared
+func (d *Debugger) showScopes(s *interpreter.State) {
+	for i, scope := range s.Scopes() {
+		title := fmt.Sprintf("Ámbito %d", i)
+		if scope.Global {
+			title = "Globales"
+		}
+		fmt.Fprintln(d.out, title+":")
+
+		for j, name := range scope.Names {
+			if _, isNative := scope.Values[j].(*values.Native); isNative || name == "" {
+				continue
+			}
+			fmt.Fprintf(d.out, "  %v = %v\n", name, scope.Values[j])
+		}
+	}
+}
+
+func (d *Debugger) showHelp() {
+	commands := []string{
+		"paso (p)             avanza una sentencia, entrando a las funciones",
+		"siguiente (n)        avanza una sentencia sin entrar a las funciones",
+		"fuera (f)            avanza hasta salir de la función actual",
+		"continuar (c)        avanza hasta el siguiente punto de interrupción",
+		"punto (b) N          pone un punto de interrupción en la línea N",
+		"quitar (d) N         quita el punto de interrupción de la línea N",
+		"locales (l)          muestra las variables visibles",
+		"vigilar (w) EXPR     muestra EXPR cada vez que el programa se detiene",
+		"imprimir (i) EXPR    muestra EXPR una vez",
+		"pila (bt)            muestra las llamadas en curso",
+		"salir (q)            termina el programa",
+	}
+
+	lines := make([]int, 0, len(d.breakpoints))
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+
+	for _, command := range commands {
+		fmt.Fprintln(d.out, "  "+command)
+	}
+	if len(lines) > 0 {
+		fmt.Fprintf(d.out, "Puntos de interrupción: %v\n", lines)
+	}
+}
@@ -3,44 +3,195 @@ package environment
 import "clase-mates-computacionales/cazuela/lexer"
 import "clase-mates-computacionales/cazuela/errorHandler"
 import "fmt"
+import "sort"
+import "sync"
 
 type env interface {
 	Define()
 	Get() interface{}
 }
 
+// An Environment holds the variables of a scope. The global one keeps them by name in
+// Values; every other scope keeps them in Slots, in the order the resolver numbered
+// them, with Names alongside for introspection. It may be shared between tasks, so
+// its variables are guarded by a lock
 type Environment struct {
 	Values    map[string]interface{}
+	Slots     []interface{}
+	Names     []string
 	Enclosing *Environment
+	lock      sync.RWMutex
 }
 
-func (e Environment) Define(name string, value interface{}) {
-	e.Values[name] = value
+// New creates an empty environment nested inside enclosing, which is nil for the global one
+func New(enclosing *Environment) *Environment {
+	if enclosing == nil {
+		return &Environment{Values: make(map[string]interface{})}
+	}
+	return &Environment{Enclosing: enclosing}
 }
 
-func (e Environment) Get(name lexer.Token) interface{} {
-	if val, ok := e.Values[name.Lexeme]; ok {
-		return val
+// NewWithCapacity creates a local environment with room for capacity variables
+func NewWithCapacity(enclosing *Environment, capacity int) *Environment {
+	return &Environment{
+		Slots:     make([]interface{}, 0, capacity),
+		Names:     make([]string, 0, capacity),
+		Enclosing: enclosing,
 	}
+}
+
+// Define adds a variable, taking the next slot in local scopes
+func (e *Environment) Define(name string, value interface{}) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
 
-	if e.Enclosing != nil {
-		return e.Enclosing.Get(name)
+	if e.Values != nil {
+		e.Values[name] = value
+		return
+	}
+
+	e.Slots = append(e.Slots, value)
+	e.Names = append(e.Names, name)
+}
+
+// Get looks a variable up by name, walking out through the enclosing scopes
+func (e *Environment) Get(name lexer.Token) interface{} {
+	if val, ok := e.Find(name.Lexeme); ok {
+		return val
 	}
 
 	errorHandler.RaiseError(errorHandler.CodeUndefinedVariable, fmt.Sprintf("Variable %v no definida", name.Lexeme), name.Line, "[Ejecución]", true)
 	return nil
 }
 
-func (e Environment) Assign(name lexer.Token, value interface{}) interface{} {
-	if _, ok := e.Values[name.Lexeme]; ok {
-		e.Values[name.Lexeme] = value
-		return value
+// Find is Get for callers that report a missing variable themselves
+func (e *Environment) Find(name string) (interface{}, bool) {
+	for scope := e; scope != nil; scope = scope.Enclosing {
+		if val, ok := scope.lookup(name); ok {
+			return val, true
+		}
 	}
+	return nil, false
+}
+
+// GetAt reads the slot of the scope depth levels out from this one
+func (e *Environment) GetAt(depth int, slot int) interface{} {
+	scope := e.ancestor(depth)
+
+	scope.lock.RLock()
+	defer scope.lock.RUnlock()
 
-	if e.Enclosing != nil {
-		return e.Enclosing.Assign(name, value)
+	return scope.Slots[slot]
+}
+
+// FindAt is Find limited to the scope depth levels out from this one
+func (e *Environment) FindAt(depth int, name string) (interface{}, bool) {
+	return e.ancestor(depth).lookup(name)
+}
+
+func (e *Environment) Assign(name lexer.Token, value interface{}) interface{} {
+	if e.Update(name.Lexeme, value) {
+		return value
 	}
 
 	errorHandler.RaiseError(errorHandler.CodeUndefinedVariable, fmt.Sprintf("Variable %v no definida", name.Lexeme), name.Line, "[Ejecución]", true)
 	return nil
 }
+
+// Update is Assign for callers that report a missing variable themselves
+func (e *Environment) Update(name string, value interface{}) bool {
+	for scope := e; scope != nil; scope = scope.Enclosing {
+		if scope.replace(name, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateAt is Update limited to the scope depth levels out from this one
+func (e *Environment) UpdateAt(depth int, name string, value interface{}) bool {
+	return e.ancestor(depth).replace(name, value)
+}
+
+// AssignAt writes the slot of the scope depth levels out from this one
+func (e *Environment) AssignAt(depth int, slot int, value interface{}) interface{} {
+	scope := e.ancestor(depth)
+
+	scope.lock.Lock()
+	defer scope.lock.Unlock()
+
+	scope.Slots[slot] = value
+	return value
+}
+
+// Snapshot copies the variables of this scope alone, the global one sorted by name
+func (e *Environment) Snapshot() ([]string, []interface{}) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if e.Values == nil {
+		names := append([]string(nil), e.Names...)
+		values := append([]interface{}(nil), e.Slots...)
+		return names, values
+	}
+
+	names := make([]string, 0, len(e.Values))
+	for name := range e.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]interface{}, len(names))
+	for i, name := range names {
+		values[i] = e.Values[name]
+	}
+	return names, values
+}
+
+func (e *Environment) ancestor(depth int) *Environment {
+	scope := e
+	for i := 0; i < depth; i++ {
+		scope = scope.Enclosing
+	}
+	return scope
+}
+
+func (e *Environment) lookup(name string) (interface{}, bool) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if e.Values != nil {
+		val, ok := e.Values[name]
+		return val, ok
+	}
+
+	for i := len(e.Names) - 1; i >= 0; i-- {
+		if e.Names[i] == name {
+			return e.Slots[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (e *Environment) replace(name string, value interface{}) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.Values != nil {
+		if _, ok := e.Values[name]; ok {
+			e.Values[name] = value
+			return true
+		}
+		return false
+	}
+
+	for i := len(e.Names) - 1; i >= 0; i-- {
+		if e.Names[i] == name {
+			e.Slots[i] = value
+			return true
+		}
+	}
+
+	return false
+}
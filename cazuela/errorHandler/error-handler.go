@@ -2,7 +2,9 @@ package errorHandler
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
 // error codes
@@ -13,46 +15,189 @@ const (
 	CodeRuntimeError      = 0x03
 	CodeUnexpectedEOF     = 0x04
 	CodeUndefinedVariable = 0x05
+	CodeStepLimit         = 0x06
+	CodeTimeLimit         = 0x07
+	CodeSizeLimit         = 0x08
+	CodeForbiddenNative   = 0x09
+	CodeCancelled         = 0x0A
+	CodeInvalidUsage      = 0x0B
+	CodeFileError         = 0x0C
+	CodeTestFailed        = 0x0D
+	CodeUnformatted       = 0x0E
 )
 
 // IgnoreFatals when true prevents the program from exiting during fatal errors
 var IgnoreFatals = false
 var HasFatalled = false
 
+// The code of the first fatal error since HasFatalled was cleared, see ExitCode
+var fatalCode = CodeAllGood
+
+// HasExited is set when a program asks to end with Exit while IgnoreFatals is on
+var HasExited = false
+
+// The code given to Exit, see ExitCode
+var exitCode = CodeAllGood
+
+// ExitCode is the code a program should exit with: the one it asked for with Exit, or
+// that of its first fatal error, the one it would have halted with. It is CodeAllGood if
+// neither happened since HasExited and HasFatalled were last cleared
+func ExitCode() int {
+	if HasExited {
+		return exitCode
+	}
+	if !HasFatalled {
+		return CodeAllGood
+	}
+	return fatalCode
+}
+
+// Exit ends the program with the code it asked for, once what was written has been
+// flushed. When IgnoreFatals is on the program goes on, with HasExited set and the
+// code kept for ExitCode, so whoever runs it decides what to do
+func Exit(code int) {
+	if IgnoreFatals || collected != nil {
+		HasExited, exitCode = true, code
+		return
+	}
+
+	os.Stdout.Sync()
+	if f, ok := Output.(*os.File); ok {
+		f.Sync()
+	}
+	os.Exit(code)
+}
+
+// Output is where errors and warnings are reported
+var Output io.Writer = os.Stdout
+
+// A Diagnostic is an error or warning gathered by Collect. Column and Length are the
+// piece of the line it is about, for those raised with RaiseErrorAt; Column counts from
+// 1 and is 0 when only the line is known
+type Diagnostic struct {
+	Code    int
+	Line    int
+	Message string
+	Context string
+	Warning bool
+	Column  int
+	Length  int
+}
+
+// The diagnostics being gathered, nil when errors are reported as usual
+var collected *[]Diagnostic
+
+// Collect runs f gathering the errors and warnings it raises instead of reporting them,
+// for tools such as editors that show them elsewhere. Fatal errors don't end the
+// program while collecting, although they still set HasFatalled
+func Collect(f func()) []Diagnostic {
+	diagnostics := []Diagnostic{}
+
+	previous, fatalled, code := collected, HasFatalled, fatalCode
+	collected, HasFatalled = &diagnostics, false
+	defer func() {
+		collected, HasFatalled, fatalCode = previous, fatalled, code
+	}()
+
+	f()
+	return diagnostics
+}
+
 // A MenudoError represents a general error during the interpretation of the program
 type MenudoError struct {
 	code    int
 	line    int
 	message string
 	context string
+	trace   string
 }
 
 func (e MenudoError) String() string {
+	if e.trace != "" {
+		return fmt.Sprintf("[%d] Error %v: %v\n\t%v", e.line, e.context, e.message, e.trace)
+	}
 	return fmt.Sprintf("[%d] Error %v: %v", e.line, e.context, e.message)
 }
 
+// A TraceFrame is a function in the middle of running, and the line it is at
+type TraceFrame struct {
+	Name string
+	Line int
+}
+
+// Traces longer than this show only their ends
+const maxTraceFrames = 10
+
+// FormatTrace describes a call stack, innermost call first
+func FormatTrace(frames []TraceFrame) string {
+	parts := make([]string, 0, len(frames))
+	for i, frame := range frames {
+		if len(frames) > maxTraceFrames && i == maxTraceFrames/2 {
+			parts = append(parts, fmt.Sprintf("… (%d llamadas más)", len(frames)-maxTraceFrames))
+		}
+		if len(frames) > maxTraceFrames && i >= maxTraceFrames/2 && i < len(frames)-maxTraceFrames/2 {
+			continue
+		}
+		if frame.Line <= 0 {
+			parts = append(parts, fmt.Sprintf("en %v", frame.Name))
+		} else {
+			parts = append(parts, fmt.Sprintf("en %v (línea %d)", frame.Name, frame.Line))
+		}
+	}
+	return strings.Join(parts, " ← ")
+}
+
 // HaltExecutionWithError stops the program reporting the error message
 func HaltExecutionWithError(mError MenudoError) {
-	fmt.Printf("\nLa cazuela se vació con el código: %X\n", mError.code)
-	fmt.Printf("\t%v", mError)
+	fmt.Fprintf(Output, "\nLa cazuela se vació con el código: %X\n", mError.code)
+	fmt.Fprintf(Output, "\t%v", mError)
 	os.Exit(mError.code)
 }
 
 // RaiseError creates a new error and outputs, halting if needed
 func RaiseError(code int, message string, line int, context string, fatal bool) {
-	mError := MenudoError{code, line, message, context}
+	RaiseErrorWithTrace(code, message, line, context, nil, fatal)
+}
 
-	if fatal && !IgnoreFatals {
+// RaiseErrorAt is RaiseError for errors about a piece of the source, such as a token,
+// length characters long from column. Only diagnostics gathered by Collect keep them
+func RaiseErrorAt(code int, message string, line int, column int, length int, context string, fatal bool) {
+	raise(MenudoError{code, line, message, context, ""}, column, length, fatal)
+}
+
+// RaiseErrorWithTrace is RaiseError for errors raised while running, along with the calls that led to them
+func RaiseErrorWithTrace(code int, message string, line int, context string, trace []TraceFrame, fatal bool) {
+	raise(MenudoError{code, line, message, context, FormatTrace(trace)}, 0, 0, fatal)
+}
+
+func raise(mError MenudoError, column int, length int, fatal bool) {
+	code := mError.code
+
+	if collected != nil {
+		*collected = append(*collected, Diagnostic{code, mError.line, mError.message, mError.context, false, column, length})
+	} else if fatal && !IgnoreFatals {
 		HaltExecutionWithError(mError)
 	} else {
-		fmt.Println(mError)
+		fmt.Fprintln(Output, mError)
 	}
 
 	if fatal {
+		if !HasFatalled {
+			fatalCode = code
+		}
 		HasFatalled = true
 	}
 }
 
+// RaiseWarning reports something suspicious that does not stop the program
+func RaiseWarning(message string, line int, context string) {
+	if collected != nil {
+		*collected = append(*collected, Diagnostic{CodeAllGood, line, message, context, true, 0, 0})
+		return
+	}
+	fmt.Fprintf(Output, "[%d] Advertencia %v: %v\n", line, context, message)
+}
+
 // RaiseErrorWithCode creates a new error giving just a code, inferring the message
 func RaiseErrorWithCode(code int) {
 	message := getErrorCodeDescription(code)
@@ -60,6 +205,11 @@ func RaiseErrorWithCode(code int) {
 
 }
 
+// CodeDescription describes what an error code means
+func CodeDescription(code int) string {
+	return getErrorCodeDescription(code)
+}
+
 func getErrorCodeDescription(errorCode int) string {
 	switch errorCode {
 	case CodeAllGood:
@@ -70,6 +220,28 @@ func getErrorCodeDescription(errorCode int) string {
 		return "Demasiados argumentos durante inicialización"
 	case CodeRuntimeError:
 		return "Error en tiempo de ejecución"
+	case CodeUnexpectedEOF:
+		return "El archivo se acabó antes de tiempo"
+	case CodeUndefinedVariable:
+		return "Variable no definida"
+	case CodeStepLimit:
+		return "Se superó el límite de pasos"
+	case CodeTimeLimit:
+		return "Se superó el límite de tiempo"
+	case CodeSizeLimit:
+		return "Se superó el límite de tamaño"
+	case CodeForbiddenNative:
+		return "Función nativa no permitida"
+	case CodeCancelled:
+		return "Ejecución cancelada"
+	case CodeInvalidUsage:
+		return "Uso incorrecto de la línea de comandos"
+	case CodeFileError:
+		return "No se pudo leer o escribir un archivo"
+	case CodeTestFailed:
+		return "Fallaron pruebas"
+	case CodeUnformatted:
+		return "Hay archivos sin formato"
 	}
 	return "Error desconocido"
 }
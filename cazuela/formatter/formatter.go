@@ -0,0 +1,212 @@
+package formatter
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"strings"
+)
+
+/*
+Rewrites Cazuela programs in a single canonical style:
+
+	- two spaces of indentation per block
+	- one statement per line, with "caso" and "otro" followed by their statement
+	- opening braces at the end of the line, "nope" right after the closing one
+	- a space around binary operators, after commas and after keywords, none around ".."
+	- at most one blank line in a row, kept where the source had one
+	- a newline at the end of the file
+
+The program is parsed first, and only formatted if it is correct. The formatting itself
+works on the tokens rather than on the AST, since the parser turns "por" loops into
+"mientras" ones and drops comments, which the lexer keeps apart. Comments stay where
+they were: on a line of their own, or after the code of a line.
+*/
+
+const indentation = "  "
+
+// Format gives the formatted source of a program, or false when it has errors, which
+// are reported as usual
+func Format(source string) (string, bool) {
+	errorHandler.HasFatalled = false
+
+	tokens := lexer.GetTokens(source)
+	comments := lexer.Comments()
+	if errorHandler.HasFatalled {
+		return "", false
+	}
+
+	parser.Parse(tokens)
+	if errorHandler.HasFatalled {
+		return "", false
+	}
+
+	p := &printer{}
+	p.print(tokens, comments)
+	return p.out.String(), true
+}
+
+type printer struct {
+	out    strings.Builder
+	indent int
+	parens int
+
+	// Colons after "caso" and "otro" still to come, and "?" still waiting for their ":"
+	caseColons int
+	questions  int
+
+	previous    *lexer.Token
+	previousEnd int
+	unary       bool
+	newline     bool
+	atLineStart bool
+	afterBrace  bool
+}
+
+func (p *printer) print(tokens []lexer.Token, comments []lexer.Comment) {
+	p.atLineStart = true
+	next := 0
+
+	for i := range tokens {
+		t := tokens[i]
+
+		for next < len(comments) && before(comments[next], t) {
+			p.comment(comments[next])
+			next++
+		}
+
+		if t.TokenType == lexer.TokenEOF {
+			break
+		}
+
+		p.token(t, tokens[i+1])
+	}
+
+	if !p.atLineStart {
+		p.out.WriteString("\n")
+	}
+}
+
+func before(c lexer.Comment, t lexer.Token) bool {
+	return c.Line < t.Line || (c.Line == t.Line && c.Column < t.Column)
+}
+
+func (p *printer) token(t lexer.Token, following lexer.Token) {
+	if t.TokenType == lexer.TokenRightBrace {
+		p.indent--
+		p.newline = p.newline || (!p.atLineStart && !p.afterBrace)
+	}
+
+	if p.newline {
+		p.startLine(t.Line, t.TokenType != lexer.TokenRightBrace)
+	} else if !p.atLineStart && p.spaceBefore(t) {
+		p.out.WriteString(" ")
+	}
+
+	p.out.WriteString(t.Lexeme)
+	p.atLineStart = false
+	p.afterBrace = t.TokenType == lexer.TokenLeftBrace
+
+	p.unary = isUnary(t, p.previous)
+	p.previous = &t
+	p.previousEnd = t.Line + strings.Count(t.Lexeme, "\n")
+
+	switch t.TokenType {
+	case lexer.TokenLeftParentheses:
+		p.parens++
+	case lexer.TokenRightParenteses:
+		p.parens--
+	case lexer.TokenCase, lexer.TokenDefault:
+		p.caseColons++
+	case lexer.TokenQuestion:
+		p.questions++
+	case lexer.TokenColon:
+		if p.questions > 0 {
+			p.questions--
+		} else if p.caseColons > 0 {
+			p.caseColons--
+		}
+	case lexer.TokenSemiColon:
+		p.newline = p.parens == 0
+	case lexer.TokenLeftBrace:
+		p.indent++
+		p.newline = following.TokenType != lexer.TokenRightBrace
+	case lexer.TokenRightBrace:
+		p.newline = following.TokenType != lexer.TokenElse
+	}
+}
+
+// spaceBefore tells whether a token is separated from the one before it in the same line
+func (p *printer) spaceBefore(t lexer.Token) bool {
+	previous := p.previous.TokenType
+
+	switch {
+	case p.unary, previous == lexer.TokenLeftParentheses, previous == lexer.TokenDotDot:
+		return false
+	case previous == lexer.TokenLeftBrace && t.TokenType == lexer.TokenRightBrace:
+		return false
+	}
+
+	switch t.TokenType {
+	case lexer.TokenRightParenteses, lexer.TokenComma, lexer.TokenSemiColon, lexer.TokenDotDot:
+		return false
+	case lexer.TokenLeftParentheses:
+		// Calls and declarations keep the parenthesis next to the name
+		return previous != lexer.TokenIdentifier && previous != lexer.TokenRightParenteses
+	case lexer.TokenColon:
+		return p.questions > 0
+	}
+
+	return true
+}
+
+// isUnary tells whether t is a "-" or "!" applied to what follows, looking at the token before it
+func isUnary(t lexer.Token, previous *lexer.Token) bool {
+	if t.TokenType == lexer.TokenNegation {
+		return true
+	}
+	if t.TokenType != lexer.TokenMinus {
+		return false
+	}
+	if previous == nil {
+		return true
+	}
+
+	switch previous.TokenType {
+	case lexer.TokenIdentifier, lexer.TokenNumber, lexer.TokenString, lexer.TokenRightParenteses,
+		lexer.TokenTrue, lexer.TokenFalse, lexer.TokenNull:
+		return false
+	}
+	return true
+}
+
+// startLine ends the current line and indents the next one. A blank line is kept
+// between them if the source had one, blank may be false to drop it
+func (p *printer) startLine(line int, blank bool) {
+	if !p.atLineStart {
+		p.out.WriteString("\n")
+	}
+
+	if blank && !p.atLineStart && !p.afterBrace && line > p.previousEnd+1 {
+		p.out.WriteString("\n")
+	}
+
+	p.out.WriteString(strings.Repeat(indentation, p.indent))
+	p.atLineStart = false
+	p.newline = false
+}
+
+func (p *printer) comment(c lexer.Comment) {
+	text := strings.TrimRight(c.Text, " \t\r")
+
+	if p.previous != nil && c.Line == p.previousEnd {
+		p.out.WriteString(" " + text)
+	} else {
+		p.startLine(c.Line, true)
+		p.out.WriteString(text)
+		p.previousEnd = c.Line
+	}
+
+	p.newline = true
+	p.afterBrace = false
+}
@@ -0,0 +1,146 @@
+package formatter
+
+import (
+	"bytes"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/interpreter"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// A program with every construct the formatter lays out, written carelessly
+const source = `#!/usr/bin/env cazuela
+// Un programa con todo lo que el formateador ordena
+var x=1+2*3;   // al final de la línea
+fn suma(a,b){sazonar a+b;}
+
+
+// en su propia línea
+si(x>5){servir "grande";}nope{servir "chico";}
+segun(x){caso 1,2:servir "poco";caso 3..20:servir -x;otro:servir x;}
+var texto = "una
+cadena   de varias
+  líneas";
+servir texto;
+por(var i=0;i<3;i=i+1){si(i==1)continuar;servir suma(i,x);}
+por cada c en "ab" servir c;
+servir x>4?"mucho":"poco";
+var n = 0;
+mientras (n < 2) { // cuenta
+    // dentro del ciclo
+  n = n + 1;
+}
+si (n == 2) servir "dos"; nope servir "otro";
+`
+
+const formatted = `#!/usr/bin/env cazuela
+// Un programa con todo lo que el formateador ordena
+var x = 1 + 2 * 3; // al final de la línea
+fn suma(a, b) {
+  sazonar a + b;
+}
+
+// en su propia línea
+si (x > 5) {
+  servir "grande";
+} nope {
+  servir "chico";
+}
+segun (x) {
+  caso 1, 2: servir "poco";
+  caso 3..20: servir -x;
+  otro: servir x;
+}
+var texto = "una
+cadena   de varias
+  líneas";
+servir texto;
+por (var i = 0; i < 3; i = i + 1) {
+  si (i == 1) continuar;
+  servir suma(i, x);
+}
+por cada c en "ab" servir c;
+servir x > 4 ? "mucho" : "poco";
+var n = 0;
+mientras (n < 2) { // cuenta
+  // dentro del ciclo
+  n = n + 1;
+}
+si (n == 2) servir "dos";
+nope servir "otro";
+`
+
+func TestFormat(t *testing.T) {
+	got, ok := Format(source)
+	if !ok {
+		t.Fatal("el programa no se pudo formatear")
+	}
+	if got != formatted {
+		t.Errorf("se esperaba:\n%v\nse obtuvo:\n%v", formatted, got)
+	}
+
+	again, ok := Format(got)
+	if !ok || again != got {
+		t.Errorf("formatear de nuevo cambió el programa:\n%v", again)
+	}
+}
+
+func TestFormatKeepsComments(t *testing.T) {
+	got, _ := Format(source)
+
+	for _, comment := range []string{
+		"#!/usr/bin/env cazuela\n",
+		"\n// Un programa con todo lo que el formateador ordena\n",
+		"; // al final de la línea\n",
+		"\n// en su propia línea\n",
+		"{ // cuenta\n",
+		"\n  // dentro del ciclo\n",
+	} {
+		if !strings.Contains(got, comment) {
+			t.Errorf("se perdió el comentario %q", comment)
+		}
+	}
+
+	if !strings.Contains(got, "\"una\ncadena   de varias\n  líneas\"") {
+		t.Error("se cambió el contenido de una cadena de varias líneas")
+	}
+}
+
+func TestFormatRejectsErrors(t *testing.T) {
+	errorHandler.IgnoreFatals, errorHandler.Output = true, ioutil.Discard
+	defer func() {
+		errorHandler.IgnoreFatals, errorHandler.Output = false, os.Stdout
+	}()
+
+	if _, ok := Format("servir (1;"); ok {
+		t.Error("se formateó un programa con errores")
+	}
+}
+
+// TestFormatKeepsBehavior runs the program before and after formatting it
+func TestFormatKeepsBehavior(t *testing.T) {
+	got, _ := Format(source)
+
+	before, after := run(source), run(got)
+	if before == "" {
+		t.Fatal("el programa no sirvió nada")
+	}
+	if before != after {
+		t.Errorf("el programa formateado sirve otra cosa\nantes:\n%v\ndespués:\n%v", before, after)
+	}
+}
+
+func run(source string) string {
+	var output bytes.Buffer
+	interpreter.Output = &output
+	defer func() { interpreter.Output = os.Stdout }()
+
+	interpreter.InitEnv()
+	interpreter.Interpret(context.Background(), parser.Parse(lexer.GetTokens(source)))
+	return output.String()
+}
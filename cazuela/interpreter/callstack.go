@@ -0,0 +1,96 @@
+package interpreter
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/values"
+	"fmt"
+)
+
+// MaxCallDepth is how many calls may be running at once in a thread before it is
+// considered a runaway recursion
+var MaxCallDepth = 10000
+
+// A call is a function a thread is in the middle of running, and the line it was called from
+type call struct {
+	name string
+	line int
+}
+
+// halt unwinds a thread after a runtime error has already been reported
+type halt struct{}
+
+func (t *thread) pushCall(fn interface{}, line int) {
+	if len(t.calls) >= MaxCallDepth {
+		t.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Desbordamiento de pila: más de %d llamadas anidadas", MaxCallDepth), line, "[Llamada]")
+	}
+
+	t.calls = append(t.calls, call{callableName(fn), line})
+}
+
+func (t *thread) popCall() {
+	t.calls = t.calls[:len(t.calls)-1]
+}
+
+func callableName(fn interface{}) string {
+	if f, ok := fn.(CazuelaFunction); ok {
+		return f.declaration.Name.Lexeme
+	}
+	if native, ok := fn.(*values.Native); ok {
+		return native.Name
+	}
+	return fmt.Sprintf("%v", fn)
+}
+
+// trace lists the calls being run, innermost first, each with the line it is at:
+// line for the innermost one, and for the others the line of the call they are waiting on
+func (t *thread) trace(line int) []errorHandler.TraceFrame {
+	frames := make([]errorHandler.TraceFrame, 0, len(t.calls)+1)
+
+	for i := len(t.calls); i >= 0; i-- {
+		name := t.name
+		if i > 0 {
+			name = t.calls[i-1].name
+		}
+
+		frames = append(frames, errorHandler.TraceFrame{Name: name, Line: line})
+
+		if i > 0 {
+			line = t.calls[i-1].line
+		}
+	}
+
+	return frames
+}
+
+// runtimeError reports an error along with the calls that led to it, and unwinds the thread
+func (t *thread) runtimeError(code int, message string, line int, context string) {
+	errorHandler.RaiseErrorWithTrace(code, message, line, context, t.trace(line), true)
+	panic(halt{})
+}
+
+func (t *thread) undefinedVariable(name lexer.Token) {
+	t.runtimeError(errorHandler.CodeUndefinedVariable, fmt.Sprintf("Variable %v no definida", name.Lexeme), name.Line, "[Ejecución]")
+}
+
+// nativeError is runtimeError for native functions. They are not Cazuela code, so the
+// error is reported from the line that called them, as if the call itself had failed
+func (t *thread) nativeError(code int, message string, context string) {
+	line := 0
+	if len(t.calls) > 0 {
+		line = t.calls[len(t.calls)-1].line
+		t.popCall()
+	}
+
+	t.runtimeError(code, message, line, context)
+}
+
+// Fail is nativeError, for the natives of the values package
+func (t *thread) Fail(code int, message string, context string) {
+	t.nativeError(code, message, context)
+}
+
+// Stop unwinds the thread, for the natives of the values package
+func (t *thread) Stop() {
+	panic(halt{})
+}
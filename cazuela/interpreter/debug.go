@@ -0,0 +1,166 @@
+package interpreter
+
+import (
+	"clase-mates-computacionales/cazuela/environment"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"fmt"
+)
+
+/*
+Hook points for tools that follow a program as it runs, such as the debugger.
+
+When DebugHook is set, execute calls it before every statement that has a line, on
+the thread about to run it. The statement waits for the hook to return, so a tool can
+pause a program simply by not returning until told to go on. Tasks run their own
+threads, so the hook may be called from several goroutines at once.
+*/
+
+// A Hook is called before a statement runs
+type Hook func(*State)
+
+// DebugHook, when set, is called before every statement
+var DebugHook Hook
+
+// A State is a look into a thread paused before a statement. Only its line, statement
+// and depth stay valid once the thread goes on
+type State struct {
+	t     *thread
+	stmt  parser.Stmt
+	line  int
+	depth int
+}
+
+// A Scope holds the variables of one environment, Global for the outermost one
+type Scope struct {
+	Names  []string
+	Values []interface{}
+	Global bool
+}
+
+func (t *thread) beforeStatement(s parser.Stmt) {
+	if t.inspecting {
+		return
+	}
+
+	if line := parser.StatementLine(s); line > 0 {
+		DebugHook(&State{t, s, line, len(t.calls)})
+	}
+}
+
+// Line is the line of the statement about to run
+func (s *State) Line() int {
+	return s.line
+}
+
+// Statement is the statement about to run
+func (s *State) Statement() parser.Stmt {
+	return s.stmt
+}
+
+// Depth is how many calls the thread is in the middle of, 0 outside of every function
+func (s *State) Depth() int {
+	return s.depth
+}
+
+// Thread names the code the thread started running, "<principal>" for the main program
+func (s *State) Thread() string {
+	return s.t.name
+}
+
+// Function names the function the statement belongs to
+func (s *State) Function() string {
+	if len(s.t.calls) == 0 {
+		return s.t.name
+	}
+	return s.t.calls[len(s.t.calls)-1].name
+}
+
+// SameThread reports whether two states come from the same thread
+func (s *State) SameThread(other *State) bool {
+	return other != nil && s.t == other.t
+}
+
+// Frames lists the calls being run, innermost first, like a stack trace
+func (s *State) Frames() []errorHandler.TraceFrame {
+	return s.t.trace(s.line)
+}
+
+// Scopes lists the variables visible to the statement, innermost scope first
+func (s *State) Scopes() []Scope {
+	var scopes []Scope
+
+	for env := s.t.env; env != nil; env = env.Enclosing {
+		names, values := env.Snapshot()
+		scopes = append(scopes, Scope{names, values, env.Enclosing == nil})
+	}
+
+	return scopes
+}
+
+// Environment is the innermost environment of the statement
+func (s *State) Environment() *environment.Environment {
+	return s.t.env
+}
+
+// Evaluate runs an expression as if it were written where the thread is paused. Its
+// variables are looked up by name, since the resolver never saw it. Errors are
+// reported as usual, at the line the thread is paused at, and make it give false
+func (s *State) Evaluate(source string) (value interface{}, ok bool) {
+	var tokens []lexer.Token
+	diagnostics := errorHandler.Collect(func() {
+		tokens = lexer.GetTokens(source + ";")
+	})
+	if len(diagnostics) > 0 {
+		for _, d := range diagnostics {
+			errorHandler.RaiseError(d.Code, d.Message, s.line+d.Line-1, d.Context, false)
+		}
+		return nil, false
+	}
+
+	// The lexer counts lines from the start of the expression
+	for i := range tokens {
+		tokens[i].Line += s.line - 1
+	}
+
+	statements := parser.Parse(tokens)
+	if errorHandler.HasFatalled {
+		errorHandler.HasFatalled = false
+		return nil, false
+	}
+
+	if len(statements) != 2 {
+		errorHandler.RaiseError(errorHandler.CodeSyntaxError, "Se esperaba una sola expresión", s.line, "[Depuración]", false)
+		return nil, false
+	}
+
+	statement, isExpression := statements[1].(parser.Statement)
+	if !isExpression {
+		errorHandler.RaiseError(errorHandler.CodeSyntaxError, "Se esperaba una expresión", s.line, "[Depuración]", false)
+		return nil, false
+	}
+
+	inspector := &thread{
+		name:       s.t.name,
+		env:        s.t.env,
+		generator:  s.t.generator,
+		calls:      append([]call(nil), s.t.calls...),
+		budget:     s.t.budget,
+		scheduler:  s.t.scheduler,
+		task:       s.t.task,
+		inspecting: true,
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			if _, isHalt := err.(halt); !isHalt {
+				errorHandler.RaiseError(errorHandler.CodeRuntimeError, "Error interno al evaluar", s.line, fmt.Sprintf("%v", err), false)
+			}
+			errorHandler.HasFatalled = false
+			value, ok = nil, false
+		}
+	}()
+
+	return inspector.evaluate(statement.Expr), true
+}
@@ -0,0 +1,38 @@
+package interpreter
+
+import (
+	"clase-mates-computacionales/cazuela/environment"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/parser"
+	"clase-mates-computacionales/cazuela/values"
+)
+
+// newGenerator creates the generator a call to f from caller gives, whose body runs on its own thread
+func newGenerator(f CazuelaFunction, localEnv *environment.Environment, caller *thread) *values.Generator {
+	name := f.declaration.Name.Lexeme
+	budget, scheduler, task := caller.budget, caller.scheduler, caller.task
+
+	return values.NewGenerator(name, func(g *values.Generator) {
+		t := &thread{name: name, env: localEnv, generator: g, budget: budget, scheduler: scheduler, task: task}
+		result := t.executeBlock(f.declaration.Body, localEnv)
+
+		// What a generator returns is discarded, but a call it returns still has to be made
+		if pending, ok := result.value.(tailCall); ok {
+			pending.function.Call(t, pending.arguments)
+		}
+	})
+}
+
+func (t *thread) executeYield(v parser.YieldStmt) {
+	var value interface{}
+	if v.Value != nil {
+		value = t.evaluate(v.Value)
+	}
+
+	if t.generator == nil {
+		t.runtimeError(errorHandler.CodeRuntimeError, "Solo se puede producir dentro de un generador", v.Keyword.Line, "[Producir]")
+		return
+	}
+
+	t.generator.Yield(t, value)
+}
@@ -5,188 +5,401 @@ import (
 	"clase-mates-computacionales/cazuela/errorHandler"
 	"clase-mates-computacionales/cazuela/lexer"
 	"clase-mates-computacionales/cazuela/parser"
+	"clase-mates-computacionales/cazuela/resolver"
+	"clase-mates-computacionales/cazuela/sandbox"
+	"clase-mates-computacionales/cazuela/values"
+	"context"
 	"fmt"
+	"io"
 	"math"
+	"os"
 	"strconv"
 )
 
-var env *environment.Environment
+// The global environment, shared by every thread
+var globals *environment.Environment
 
 var ShouldPrintAllExpressions = false
 
-type Callable interface {
-	arity() int
-	Call([]interface{}) interface{}
+// Output is where "servir" writes, tools that own the standard output can point it elsewhere
+var Output io.Writer = os.Stdout
+
+// A thread is a single line of execution: the main program, a task or a generator.
+// Each one keeps track of its own current environment and calls so they can run side
+// by side. The name is what stack traces call the code the thread started running.
+// An inspecting thread evaluates expressions for a tool, see debug.go
+type thread struct {
+	name       string
+	env        *environment.Environment
+	generator  *values.Generator
+	calls      []call
+	budget     *sandbox.Budget
+	scheduler  *values.Scheduler
+	task       bool
+	steps      int64
+	nextCheck  int64
+	line       int
+	inspecting bool
 }
 
+// Statements complete normally, or abruptly by returning or leaving a loop.
+// The kind tells the enclosing statements how to continue
+const (
+	completionNormal = iota
+	completionReturn
+	completionBreak
+	completionContinue
+)
+
+type completion struct {
+	kind  int
+	value interface{}
+}
+
+var normalCompletion = completion{kind: completionNormal}
+
+// A CazuelaFunction is a user declared function, along with the environment it was declared in
 type CazuelaFunction struct {
 	declaration parser.FnDecl
+	closure     *environment.Environment
 }
 
-func (f CazuelaFunction) Call(arguments []interface{}) (response interface{}) {
-	localEnv := environment.Environment{make(map[string]interface{}), env}
+// Call runs the body of the function. A call in tail position hands back a tailCall
+// instead of making it, and it is made here in a loop, so the host stack doesn't grow
+// with every call in accumulator style recursion
+func (f CazuelaFunction) Call(t *thread, arguments []interface{}) interface{} {
+	for {
+		localEnv := environment.NewWithCapacity(f.closure, len(arguments))
 
-	for i := 0; i < len(arguments); i++ {
-		localEnv.Define(f.declaration.Parameters[i].Lexeme, arguments[i])
-	}
+		for i := 0; i < len(arguments); i++ {
+			localEnv.Define(f.declaration.Parameters[i].Lexeme, arguments[i])
+		}
 
-	defer func() {
-		if returnValue := recover(); returnValue != nil {
-			response = returnValue
+		if f.declaration.IsGenerator {
+			return newGenerator(f, localEnv, t)
 		}
-	}()
 
-	executeBlock(f.declaration.Body, localEnv)
+		result := t.executeBlock(f.declaration.Body, localEnv)
+
+		pending, ok := result.value.(tailCall)
+		if !ok {
+			return result.value
+		}
+
+		f, arguments = pending.function, pending.arguments
+		if len(t.calls) > 0 {
+			t.calls[len(t.calls)-1].name = f.declaration.Name.Lexeme
+		} else {
+			t.name = f.declaration.Name.Lexeme
+		}
+	}
+}
+
+func (f CazuelaFunction) String() string {
+	return fmt.Sprintf("<fn %v>", f.declaration.Name.Lexeme)
+}
+
+func (f CazuelaFunction) FunctionName() string {
+	return f.declaration.Name.Lexeme
+}
+
+// A tailCall is a call left pending by "sazonar f(...)" for the caller to make
+type tailCall struct {
+	function  CazuelaFunction
+	arguments []interface{}
+}
 
-	return
+// call makes a call to a CazuelaFunction or a native, the only values prepareCall lets
+// through. Natives don't get the leading empty argument the parser reserves in every call
+func (t *thread) call(fn interface{}, arguments []interface{}) interface{} {
+	if native, ok := fn.(*values.Native); ok {
+		return native.Function(t, arguments[1:])
+	}
+	return fn.(CazuelaFunction).Call(t, arguments)
 }
 
-func (f CazuelaFunction) arity() int {
-	return len(f.declaration.Parameters)
+// iterate returns an iterator over a value, reporting an error when it cannot be walked through
+func (t *thread) iterate(value interface{}, keyword lexer.Token) values.Iterator {
+	if it, ok := values.Iterate(t, value); ok {
+		return it
+	}
+
+	if c, ok := value.(*values.Channel); ok {
+		return &channelIterator{t, c, keyword.Line}
+	}
+
+	t.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("No se puede recorrer %v", value), keyword.Line, "[Por cada]")
+	return nil
 }
 
 func InitEnv() {
-	env = &environment.Environment{make(map[string]interface{}), nil}
+	globals = environment.New(nil)
+	values.DefineGlobals(globals.Define)
+}
 
-	env.Define("pi", 3.141592653589793)
-	env.Define("e", 2.718281828459045)
+// Globals is the global environment, for tools that show what the program defined
+func Globals() *environment.Environment {
+	return globals
 }
 
-// Interpret takes an AST and interprets it (magic!)
-func Interpret(stmts []parser.Stmt) {
+// Interpret takes an AST and interprets it (magic!), until it finishes, fails, or ctx is done
+func Interpret(ctx context.Context, stmts []parser.Stmt) {
 	defer func() {
 		if err := recover(); err != nil {
-			errorHandler.RaiseError(errorHandler.CodeRuntimeError, "Error interno en tiempo de ejecución", -1, fmt.Sprintf("%v", err), true)
+			if _, isHalt := err.(halt); !isHalt {
+				errorHandler.RaiseError(errorHandler.CodeRuntimeError, "Error interno en tiempo de ejecución", -1, fmt.Sprintf("%v", err), true)
+			}
 		}
 	}()
 
-	for _, s := range stmts {
-		execute(s)
+	budget := sandbox.NewBudget(ctx, Limits)
+	defer budget.Release()
+
+	t := &thread{name: "<principal>", env: globals, budget: budget, scheduler: values.NewScheduler()}
+
+	for _, s := range resolver.Resolve(stmts) {
+		t.execute(s)
 	}
 }
 
-func execute(s parser.Stmt) {
+func (t *thread) execute(s parser.Stmt) completion {
+	// The empty statements the parser leaves as placeholders aren't steps
+	if s == nil {
+		return normalCompletion
+	}
+
+	if line := parser.StatementLine(s); line > 0 {
+		t.line = line
+	}
+	t.step()
+
+	if DebugHook != nil {
+		t.beforeStatement(s)
+	}
+
 	if v, ok := s.(parser.Statement); ok {
-		evaluateStatement(v)
+		t.evaluateStatement(v)
 	} else if v, ok := s.(parser.Print); ok {
-		evaluatePrint(v)
+		t.evaluatePrint(v)
 	} else if v, ok := s.(parser.Declaration); ok {
-		evaluateDeclaration(v)
+		t.evaluateDeclaration(v)
 	} else if v, ok := s.(parser.Block); ok {
-		executeBlock(v.Statements, environment.Environment{make(map[string]interface{}), env})
+		return t.executeBlock(v.Statements, environment.New(t.env))
 	} else if v, ok := s.(parser.If); ok {
-		executeIf(v)
+		return t.executeIf(v)
 	} else if v, ok := s.(parser.While); ok {
-		executeWhile(v)
+		return t.executeWhile(v)
 	} else if v, ok := s.(parser.FnDecl); ok {
-		fn := CazuelaFunction{v}
-		env.Define(v.Name.Lexeme, fn)
+		fn := CazuelaFunction{v, t.env}
+		t.env.Define(v.Name.Lexeme, fn)
+	} else if v, ok := s.(parser.ForEach); ok {
+		return t.executeForEach(v)
+	} else if v, ok := s.(parser.Switch); ok {
+		return t.executeSwitch(v)
 	} else if v, ok := s.(parser.ReturnStmt); ok {
-		executeReturn(v)
+		return t.executeReturn(v)
+	} else if _, ok := s.(parser.BreakStmt); ok {
+		return completion{kind: completionBreak}
+	} else if _, ok := s.(parser.ContinueStmt); ok {
+		return completion{kind: completionContinue}
+	} else if v, ok := s.(parser.YieldStmt); ok {
+		t.executeYield(v)
+	}
+
+	return normalCompletion
+}
+
+func (t *thread) executeSwitch(v parser.Switch) completion {
+	subject := t.evaluate(v.Subject)
+
+	for _, c := range v.Cases {
+		for _, pattern := range c.Patterns {
+			if values.Matches(subject, t.evaluate(pattern)) {
+				return t.execute(c.Body)
+			}
+		}
+	}
+
+	if v.Default != nil {
+		return t.execute(v.Default)
 	}
+
+	return normalCompletion
 }
 
-func executeWhile(v parser.While) {
-	for isTruthy(evaluate(v.Condition)) {
-		execute(v.Body)
+// The increment of a desugared "por" runs even when the body continues.
+// The optimizer leaves loops that always run without a condition
+func (t *thread) executeWhile(v parser.While) completion {
+	for v.Condition == nil || values.Truthy(t.evaluate(v.Condition)) {
+		result := t.execute(v.Body)
+
+		if result.kind == completionBreak {
+			break
+		}
+
+		if result.kind == completionReturn {
+			return result
+		}
+
+		if v.Increment != nil {
+			t.evaluate(v.Increment)
+		}
 	}
+
+	return normalCompletion
 }
 
-func executeReturn(v parser.ReturnStmt) {
+// Every iteration gets its own environment, so closures capture the value of that iteration.
+// Leaving the loop early closes a generator it goes through
+func (t *thread) executeForEach(v parser.ForEach) completion {
+	it := t.iterate(t.evaluate(v.Iterable), v.Keyword)
+	if it == nil {
+		return normalCompletion
+	}
+
+	for value, ok := it.Next(); ok; value, ok = it.Next() {
+		iterationEnv := environment.New(t.env)
+		iterationEnv.Define(v.Variable.Lexeme, value)
+		result := t.executeBlock([]parser.Stmt{v.Body}, iterationEnv)
+
+		if result.kind == completionBreak {
+			values.Abandon(it)
+			break
+		}
+
+		if result.kind == completionReturn {
+			values.Abandon(it)
+			return result
+		}
+	}
+
+	return normalCompletion
+}
+
+func (t *thread) executeReturn(v parser.ReturnStmt) completion {
+	if call, ok := v.Value.(parser.CallExpression); ok {
+		return t.executeTailCall(call)
+	}
+
 	var value interface{}
 	if v.Value != nil {
-		value = evaluate(v.Value)
+		value = t.evaluate(v.Value)
+	}
+
+	return completion{completionReturn, value}
+}
+
+// Only calls to other Cazuela functions are left pending, natives and generators are
+// called right away since they don't run a body on this stack
+func (t *thread) executeTailCall(expr parser.CallExpression) completion {
+	fn, arguments := t.prepareCall(expr)
+	if fn == nil {
+		return completion{completionReturn, nil}
 	}
 
-	panic(value)
+	if f, ok := fn.(CazuelaFunction); ok && !f.declaration.IsGenerator {
+		return completion{completionReturn, tailCall{f, arguments}}
+	}
+
+	t.pushCall(fn, expr.ClosingParenteses.Line)
+	received := t.call(fn, arguments)
+	t.popCall()
+
+	return completion{completionReturn, received}
 }
 
-func executeBlock(statements []parser.Stmt, localEnv environment.Environment) {
-	previousEnv := env
+// executeBlock runs statements until one of them completes abruptly, passing that completion up
+func (t *thread) executeBlock(statements []parser.Stmt, localEnv *environment.Environment) completion {
+	previousEnv := t.env
 
 	defer func() {
-		env = previousEnv
+		t.env = previousEnv
 	}()
 
-	env = &localEnv
+	t.env = localEnv
 
 	for _, statement := range statements {
-		execute(statement)
+		if result := t.execute(statement); result.kind != completionNormal {
+			return result
+		}
 	}
 
+	return normalCompletion
 }
 
-func evaluateDeclaration(st parser.Declaration) {
+func (t *thread) evaluateDeclaration(st parser.Declaration) {
 	var value interface{}
 	if st.Initializer != nil {
-		value = evaluate(st.Initializer)
+		value = t.evaluate(st.Initializer)
 	}
 
-	env.Define(st.Name.Lexeme, value)
+	t.env.Define(st.Name.Lexeme, value)
 }
 
-func evaluateStatement(st parser.Statement) {
-	r := evaluate(st.Expr)
+func (t *thread) evaluateStatement(st parser.Statement) {
+	r := t.evaluate(st.Expr)
 
 	if ShouldPrintAllExpressions {
-		fmt.Printf("<| %v |>\n", r)
+		fmt.Fprintf(Output, "<| %v |>\n", r)
 	}
 }
 
-func evaluatePrint(st parser.Print) {
-	value := evaluate(st.Expr)
-	fmt.Println(value)
+func (t *thread) evaluatePrint(st parser.Print) {
+	value := t.evaluate(st.Expr)
+	fmt.Fprintln(Output, value)
 }
 
-func executeIf(ifStmt parser.If) {
-	if isTruthy(evaluate(ifStmt.Condition)) {
-		execute(ifStmt.ThenBranch)
+func (t *thread) executeIf(ifStmt parser.If) completion {
+	if values.Truthy(t.evaluate(ifStmt.Condition)) {
+		return t.execute(ifStmt.ThenBranch)
 	} else if ifStmt.ElseBranch != nil {
-		execute(ifStmt.ElseBranch)
+		return t.execute(ifStmt.ElseBranch)
 	}
+
+	return normalCompletion
 }
 
 func getLiteralValue(expr parser.LiteralExpression) interface{} {
 	return expr.Value
 }
 
-func getGroupValue(expr parser.GroupingExpression) interface{} {
-	return evaluate(expr.Expression)
+func (t *thread) getGroupValue(expr parser.GroupingExpression) interface{} {
+	return t.evaluate(expr.Expression)
 }
 
-func getUnaryValue(expr parser.UnaryExpression) interface{} {
-	right := evaluate(expr.Right)
+func (t *thread) getUnaryValue(expr parser.UnaryExpression) interface{} {
+	right := t.evaluate(expr.Right)
 
 	switch expr.Operator.TokenType {
 	case lexer.TokenMinus:
-		checkNumberOperand(expr.Operator, right)
+		t.checkNumberOperand(expr.Operator, right)
 		return -right.(float64)
 	case lexer.TokenNegation:
-		return !isTruthy(right)
+		return !values.Truthy(right)
 	}
 
 	return nil
 }
 
-func getBinaryValue(expr parser.BinaryExpression) interface{} {
-	left := evaluate(expr.Left)
-	right := evaluate(expr.Right)
+func (t *thread) getBinaryValue(expr parser.BinaryExpression) interface{} {
+	left := t.evaluate(expr.Left)
+	right := t.evaluate(expr.Right)
 
 	switch expr.Operator.TokenType {
 	case lexer.TokenMinus:
-		checkNumberOperands(expr.Operator, left, right)
+		t.checkNumberOperands(expr.Operator, left, right)
 		return left.(float64) - right.(float64)
 	case lexer.TokenDivision:
-		checkNumberOperands(expr.Operator, left, right)
+		t.checkNumberOperands(expr.Operator, left, right)
 		return left.(float64) / right.(float64)
 	case lexer.TokenMult:
-		checkNumberOperands(expr.Operator, left, right)
+		t.checkNumberOperands(expr.Operator, left, right)
 		return left.(float64) * right.(float64)
 	case lexer.TokenModulo:
-		checkNumberOperands(expr.Operator, left, right)
+		t.checkNumberOperands(expr.Operator, left, right)
 		return float64(int(left.(float64)) % int(right.(float64)))
 	case lexer.TokenExponentation:
-		checkNumberOperands(expr.Operator, left, right)
+		t.checkNumberOperands(expr.Operator, left, right)
 		return math.Pow(left.(float64), right.(float64))
 	case lexer.TokenPlus:
 		l, isLFloat := left.(float64)
@@ -200,133 +413,216 @@ func getBinaryValue(expr parser.BinaryExpression) interface{} {
 		rightString, isRString := right.(string)
 
 		if isLString || isRString {
+			var result string
 			if isLString && !isRString {
-				return leftString + strconv.FormatFloat(r, 'f', -1, 64)
+				result = leftString + strconv.FormatFloat(r, 'f', -1, 64)
 			} else if !isLString && isRString {
-				return strconv.FormatFloat(l, 'f', -1, 64) + rightString
+				result = strconv.FormatFloat(l, 'f', -1, 64) + rightString
 			} else {
-				return leftString + rightString
+				result = leftString + rightString
+			}
+
+			if code, message := t.budget.CheckString(result); code != errorHandler.CodeAllGood {
+				t.runtimeError(code, message, expr.Operator.Line, "[Límite]")
 			}
+			return result
 		}
 
-		errorHandler.RaiseError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaba números o cadenas para %v", expr.Operator.Lexeme), expr.Operator.Line, "[Suma]", true)
+		t.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaba números o cadenas para %v", expr.Operator.Lexeme), expr.Operator.Line, "[Suma]")
 
 		return nil
 	case lexer.TokenGreaterThan:
-		checkNumberOperands(expr.Operator, left, right)
+		t.checkNumberOperands(expr.Operator, left, right)
 		return left.(float64) > right.(float64)
 	case lexer.TokenGreaterEqual:
-		checkNumberOperands(expr.Operator, left, right)
+		t.checkNumberOperands(expr.Operator, left, right)
 		return left.(float64) >= right.(float64)
 	case lexer.TokenLessThan:
-		checkNumberOperands(expr.Operator, left, right)
+		t.checkNumberOperands(expr.Operator, left, right)
 		return left.(float64) < right.(float64)
 	case lexer.TokenLessEqual:
-		checkNumberOperands(expr.Operator, left, right)
+		t.checkNumberOperands(expr.Operator, left, right)
 		return left.(float64) <= right.(float64)
 	case lexer.TokenNotEqualTo:
-		return !isEqual(left, right)
+		return !values.Equal(left, right)
 	case lexer.TokenEqualEqual:
-		return isEqual(left, right)
+		return values.Equal(left, right)
 
 	}
 
 	return nil
 }
 
-func checkNumberOperand(operator lexer.Token, operand interface{}) {
-	if v, ok := operand.(float64); !ok {
-		errorHandler.RaiseError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaba un número para %v, se obtuvo %v", operator.Lexeme, v), operator.Line, "[Unaria]", true)
+// Errors show the operands as they are, as the VM does
+func (t *thread) checkNumberOperand(operator lexer.Token, operand interface{}) {
+	if _, ok := operand.(float64); !ok {
+		t.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaba un número para %v, se obtuvo %v", operator.Lexeme, operand), operator.Line, "[Unaria]")
 	}
 }
 
-func checkNumberOperands(operator lexer.Token, left interface{}, right interface{}) {
-	l, isNum := left.(float64)
-	r, isNumR := right.(float64)
+func (t *thread) checkNumberOperands(operator lexer.Token, left interface{}, right interface{}) {
+	_, isNum := left.(float64)
+	_, isNumR := right.(float64)
 
 	if !(isNum && isNumR) {
-		errorHandler.RaiseError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaban números para %v, se obtuvo %v y %v", operator.Lexeme, l, r), operator.Line, "[Binaria]", true)
+		t.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaban números para %v, se obtuvo %v y %v", operator.Lexeme, left, right), operator.Line, "[Binaria]")
 	}
 }
 
-func isEqual(a, b interface{}) bool {
-	if a == nil && b == nil {
-		return true
+func (t *thread) evaluateLogicalExpression(expr parser.LogicalExpression) interface{} {
+	left := t.evaluate(expr.Left)
+
+	if expr.Operator.TokenType == lexer.TokenNullCoalescing {
+		if left != nil {
+			return left
+		}
+	} else if expr.Operator.TokenType == lexer.TokenOr {
+		if values.Truthy(left) {
+			return left
+		}
+	} else if !values.Truthy(left) {
+		return left
 	}
 
-	if a == nil || b == nil {
-		return false
+	return t.evaluate(expr.Right)
+}
+
+func (t *thread) evaluateConditionalExpression(expr parser.ConditionalExpression) interface{} {
+	if values.Truthy(t.evaluate(expr.Condition)) {
+		return t.evaluate(expr.ThenBranch)
 	}
 
-	return a == b
+	return t.evaluate(expr.ElseBranch)
 }
 
-func isTruthy(v interface{}) bool {
-	if v == nil {
-		return false
+func (t *thread) evaluateRangeExpression(expr parser.RangeExpression) interface{} {
+	start := t.evaluate(expr.Start)
+	end := t.evaluate(expr.End)
+
+	var step interface{} = 1.0
+	if expr.Step != nil {
+		step = t.evaluate(expr.Step)
 	}
 
-	if b, ok := v.(bool); ok {
-		return b
+	_, isStartNum := start.(float64)
+	_, isEndNum := end.(float64)
+	if !(isStartNum && isEndNum) {
+		t.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaban números para .., se obtuvo %v y %v", start, end), expr.Operator.Line, "[Rango]")
+	}
+	if _, isStepNum := step.(float64); !isStepNum {
+		t.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaba un número para paso, se obtuvo %v", step), expr.Operator.Line, "[Rango]")
 	}
 
-	return true
-}
+	if step == 0.0 {
+		t.runtimeError(errorHandler.CodeRuntimeError, "El paso de un rango no puede ser 0", expr.Operator.Line, "[Rango]")
+		return nil
+	}
 
-func evaluateLogicalExpression(expr parser.LogicalExpression) interface{} {
-	left := evaluate(expr.Left)
+	return values.Range{Start: start.(float64), End: end.(float64), Step: step.(float64)}
+}
 
-	if expr.Operator.TokenType == lexer.TokenOr {
-		if isTruthy(left) {
-			return left
-		}
-	} else if !isTruthy(left) {
-		return left
+func (t *thread) evaluateCallExpression(expr parser.CallExpression) interface{} {
+	fn, arguments := t.prepareCall(expr)
+	if fn == nil {
+		return nil
 	}
 
-	return evaluate(expr.Right)
+	t.pushCall(fn, expr.ClosingParenteses.Line)
+	received := t.call(fn, arguments)
+	t.popCall()
+
+	return received
 }
 
-func evaluateCallExpression(expr parser.CallExpression) interface{} {
-	callee := evaluate(expr.Callee)
+// prepareCall evaluates the callee and arguments of a call, checking it can be made
+func (t *thread) prepareCall(expr parser.CallExpression) (interface{}, []interface{}) {
+	callee := t.evaluate(expr.Callee)
 
 	arguments := make([]interface{}, 0)
 
 	for _, arg := range expr.Arguments {
-		arguments = append(arguments, evaluate(arg))
+		arguments = append(arguments, t.evaluate(arg))
 	}
 
-	if fn, ok := callee.(Callable); ok {
-		if len(arguments) != fn.arity() {
-			errorHandler.RaiseError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaban %d argumentos pero se recibieron %d", fn.arity(), len(arguments)), expr.ClosingParenteses.Line, "Función", true)
+	arity := 0
+	if fn, ok := callee.(CazuelaFunction); ok {
+		arity = len(fn.declaration.Parameters)
+	} else if native, ok := callee.(*values.Native); ok {
+		if code, message := t.budget.CheckNative(native.Name); code != errorHandler.CodeAllGood {
+			t.runtimeError(code, message, expr.ClosingParenteses.Line, "[Límite]")
 		}
-		received := fn.Call(arguments)
-		return received
+		arity = native.Arity + 1
+	} else {
+		t.runtimeError(errorHandler.CodeRuntimeError, "Se intentó llamar algo que no es una función", expr.ClosingParenteses.Line, "Función")
+		return nil, nil
 	}
 
-	errorHandler.RaiseError(errorHandler.CodeRuntimeError, "Se intentó llamar algo que no es una función", expr.ClosingParenteses.Line, "Función", true)
-	return nil
-
+	if len(arguments) != arity {
+		t.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaban %d argumentos pero se recibieron %d", arity, len(arguments)), expr.ClosingParenteses.Line, "Función")
+	}
+	return callee, arguments
 }
 
-func evaluate(expr parser.Expression) interface{} {
+func (t *thread) evaluate(expr parser.Expression) interface{} {
 	if v, ok := expr.(parser.LiteralExpression); ok {
 		return getLiteralValue(v)
 	} else if v, ok := expr.(parser.GroupingExpression); ok {
-		return getGroupValue(v)
+		return t.getGroupValue(v)
 	} else if v, ok := expr.(parser.UnaryExpression); ok {
-		return getUnaryValue(v)
+		return t.getUnaryValue(v)
 	} else if v, ok := expr.(parser.BinaryExpression); ok {
-		return getBinaryValue(v)
+		return t.getBinaryValue(v)
 	} else if v, ok := expr.(parser.VariableExpression); ok {
-		return env.Get(v.Name)
+		if v.Depth == parser.GlobalDepth {
+			scope := globals
+			if t.inspecting {
+				scope = t.env
+			}
+
+			value, ok := scope.Find(v.Name.Lexeme)
+			if !ok {
+				t.undefinedVariable(v.Name)
+			}
+			return value
+		}
+		if v.Slot == parser.UnknownSlot {
+			value, ok := t.env.FindAt(v.Depth, v.Name.Lexeme)
+			if !ok {
+				t.undefinedVariable(v.Name)
+			}
+			return value
+		}
+		return t.env.GetAt(v.Depth, v.Slot)
 	} else if v, ok := expr.(parser.AssignmentExpression); ok {
-		value := evaluate(v.Value)
-		return env.Assign(v.Name, value)
+		value := t.evaluate(v.Value)
+		if v.Depth == parser.GlobalDepth {
+			scope := globals
+			if t.inspecting {
+				scope = t.env
+			}
+
+			if !scope.Update(v.Name.Lexeme, value) {
+				t.undefinedVariable(v.Name)
+			}
+			return value
+		}
+		if v.Slot == parser.UnknownSlot {
+			if !t.env.UpdateAt(v.Depth, v.Name.Lexeme, value) {
+				t.undefinedVariable(v.Name)
+			}
+			return value
+		}
+		return t.env.AssignAt(v.Depth, v.Slot, value)
 	} else if v, ok := expr.(parser.LogicalExpression); ok {
-		return evaluateLogicalExpression(v)
+		return t.evaluateLogicalExpression(v)
+	} else if v, ok := expr.(parser.ConditionalExpression); ok {
+		return t.evaluateConditionalExpression(v)
+	} else if v, ok := expr.(parser.RangeExpression); ok {
+		return t.evaluateRangeExpression(v)
 	} else if v, ok := expr.(parser.CallExpression); ok {
-		return evaluateCallExpression(v)
+		return t.evaluateCallExpression(v)
+	} else if v, ok := expr.(parser.SpawnExpression); ok {
+		return t.evaluateSpawnExpression(v)
 	}
 
 	return nil
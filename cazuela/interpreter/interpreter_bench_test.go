@@ -0,0 +1,42 @@
+package interpreter
+
+import (
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// BenchmarkPrograms runs every program in the benchmarks folder, each iteration in a fresh
+// global environment. Lexing and parsing happen once, outside of what is measured
+func BenchmarkPrograms(b *testing.B) {
+	programs, err := filepath.Glob("../benchmarks/*.caz")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(programs) == 0 {
+		b.Fatal("no hay programas en benchmarks")
+	}
+
+	previous := Output
+	Output = ioutil.Discard
+	defer func() { Output = previous }()
+
+	for _, program := range programs {
+		source, err := ioutil.ReadFile(program)
+		if err != nil {
+			b.Fatal(err)
+		}
+		statements := parser.Parse(lexer.GetTokens(string(source)))
+
+		b.Run(strings.TrimSuffix(filepath.Base(program), ".caz"), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				InitEnv()
+				Interpret(context.Background(), statements)
+			}
+		})
+	}
+}
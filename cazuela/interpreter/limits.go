@@ -0,0 +1,60 @@
+package interpreter
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/sandbox"
+	"clase-mates-computacionales/cazuela/values"
+)
+
+// Limits are enforced on every program run by Interpret, see the sandbox package
+var Limits sandbox.Limits
+
+// step counts a statement or loop iteration, checking the budget as often as it asks
+func (t *thread) step() {
+	t.steps++
+	if t.steps < t.nextCheck {
+		return
+	}
+
+	// The next interval depends on how many steps are left once these are spent
+	code, message := t.budget.Spend(t.steps)
+	t.steps, t.nextCheck = 0, t.budget.Interval()
+	t.checkLimit(code, message)
+}
+
+// checkLimit ends the program when a limit has been exceeded or it has been cancelled,
+// reporting it at the statement the thread is running
+func (t *thread) checkLimit(code int, message string) {
+	if code != errorHandler.CodeAllGood {
+		if t.budget.Finished() {
+			panic(halt{})
+		}
+		t.runtimeError(code, message, t.line, "[Límite]")
+	}
+}
+
+// Budget is that of the program the thread is part of, for the natives of the values package
+func (t *thread) Budget() *sandbox.Budget {
+	return t.budget
+}
+
+// A channelIterator lets "por cada" receive from a channel until it is closed
+type channelIterator struct {
+	t    *thread
+	c    *values.Channel
+	line int
+}
+
+func (it *channelIterator) Next() (interface{}, bool) {
+	// It waits at the loop, not at the last statement of its body
+	it.t.line = it.line
+
+	value, ok, reason := it.c.Receive(it.t)
+	switch reason {
+	case values.Expired:
+		it.t.checkLimit(it.t.budget.Expired())
+	case values.Deadlocked:
+		it.t.runtimeError(errorHandler.CodeRuntimeError, values.Deadlock, it.t.line, "[Bloqueo]")
+	}
+	return value, ok
+}
@@ -0,0 +1,86 @@
+package interpreter
+
+import (
+	"bytes"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"clase-mates-computacionales/cazuela/sandbox"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runLimited runs a program under limits in a fresh environment, giving what it wrote,
+// errors included, and the code it would have exited with
+func runLimited(ctx context.Context, source string, limits sandbox.Limits) (string, int) {
+	var output bytes.Buffer
+	Output, errorHandler.Output = &output, &output
+	errorHandler.IgnoreFatals = true
+	errorHandler.HasFatalled, errorHandler.HasExited = false, false
+	Limits = limits
+
+	defer func() {
+		Limits = sandbox.Limits{}
+		errorHandler.IgnoreFatals = false
+	}()
+
+	InitEnv()
+	Interpret(ctx, parser.Parse(lexer.GetTokens(source)))
+	return output.String(), errorHandler.ExitCode()
+}
+
+func TestLimits(t *testing.T) {
+	forever := "mientras (verdadero) {}"
+	cases := []struct {
+		name   string
+		source string
+		limits sandbox.Limits
+		// cancel is how long the run goes on before its context is cancelled, 0 for never
+		cancel time.Duration
+		code   int
+	}{
+		{"pasos", forever, sandbox.Limits{MaxSteps: 1000}, 0, errorHandler.CodeStepLimit},
+		{"tiempo", forever, sandbox.Limits{Timeout: 50 * time.Millisecond}, 0, errorHandler.CodeTimeLimit},
+		{"tiempo esperando", "fn f() { mientras (verdadero) {} } var c = canal(); lanzar f(); recibir(c);", sandbox.Limits{Timeout: 50 * time.Millisecond}, 0, errorHandler.CodeTimeLimit},
+		{"cadenas", `var s = "a"; mientras (verdadero) s = s + s;`, sandbox.Limits{MaxStringLength: 1000}, 0, errorHandler.CodeSizeLimit},
+		{"nativas", "servir siguiente; canal();", sandbox.Limits{Natives: []string{"siguiente"}}, 0, errorHandler.CodeForbiddenNative},
+		{"cancelado", forever, sandbox.Limits{}, 50 * time.Millisecond, errorHandler.CodeCancelled},
+		{"dentro de los límites", `servir "a" + "b";`, sandbox.Limits{MaxSteps: 10, MaxStringLength: 2, Natives: []string{}}, 0, errorHandler.CodeAllGood},
+	}
+
+	for _, c := range cases {
+		ctx, cancel := context.WithCancel(context.Background())
+		if c.cancel > 0 {
+			time.AfterFunc(c.cancel, cancel)
+		}
+
+		output, code := runLimited(ctx, c.source, c.limits)
+		cancel()
+		if code != c.code {
+			t.Errorf("%v: se esperaba el código %X, se obtuvo %X\n%v", c.name, c.code, code, output)
+		}
+	}
+}
+
+// TestStepLimitIsExact checks that a program stops on the very step that goes past the
+// limit, every statement being one step
+func TestStepLimitIsExact(t *testing.T) {
+	source := "servir 1;\nservir 2;\nservir 3;\nservir 4;\nservir 5;\n"
+	printed := []string{"1\n", "2\n", "3\n", "4\n", "5\n"}
+
+	for steps := 1; steps <= len(printed); steps++ {
+		output, code := runLimited(context.Background(), source, sandbox.Limits{MaxSteps: int64(steps)})
+
+		want, wantCode := strings.Join(printed[:steps], ""), errorHandler.CodeAllGood
+		if steps < len(printed) {
+			// It stops before the statement that would go past the limit
+			want, wantCode = want+fmt.Sprintf("[%d] Error [Límite]", steps+1), errorHandler.CodeStepLimit
+		}
+		if !strings.HasPrefix(output, want) || code != wantCode {
+			t.Errorf("con %d pasos se esperaba %q con el código %X, se obtuvo %q con el código %X", steps, want, wantCode, output, code)
+		}
+	}
+}
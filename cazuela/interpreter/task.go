@@ -0,0 +1,53 @@
+package interpreter
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/parser"
+	"clase-mates-computacionales/cazuela/values"
+	"fmt"
+)
+
+// The callee and arguments are evaluated by the launching thread, only the call itself runs concurrently
+func (t *thread) evaluateSpawnExpression(expr parser.SpawnExpression) interface{} {
+	fn, arguments := t.prepareCall(expr.Call)
+	if fn == nil {
+		return nil
+	}
+
+	name := fmt.Sprintf("%v", fn)
+	if f, ok := fn.(values.Function); ok {
+		name = f.FunctionName()
+	}
+	task := t.scheduler.Spawn(name)
+
+	// Made before starting, as the launching thread goes on changing its environment
+	taskThread := &thread{name: name, env: t.env, budget: t.budget, scheduler: t.scheduler, task: true}
+
+	go func() {
+		var result interface{}
+		defer func() {
+			task.Finish(result)
+		}()
+		defer func() {
+			if err := recover(); err != nil {
+				if _, isHalt := err.(halt); !isHalt {
+					errorHandler.RaiseError(errorHandler.CodeRuntimeError, "Error interno en la tarea", expr.Keyword.Line, fmt.Sprintf("%v", err), true)
+				}
+			}
+		}()
+
+		result = taskThread.call(fn, arguments)
+	}()
+
+	return task
+}
+
+// Scheduler is that of the run the thread is part of, for the natives of the values package
+func (t *thread) Scheduler() *values.Scheduler {
+	return t.scheduler
+}
+
+// Task reports whether the thread runs a task, for the natives of the values package
+func (t *thread) Task() bool {
+	return t.task
+}
@@ -4,6 +4,7 @@ import (
 	"clase-mates-computacionales/cazuela/errorHandler"
 	"clase-mates-computacionales/utilities"
 	"fmt"
+	"sort"
 	"strconv"
 )
 
@@ -27,6 +28,10 @@ const (
 	TokenEOF             = 0x16
 	TokenSemiColon       = 0x17
 	TokenNegation        = 0x18
+	TokenQuestion        = 0x19
+	TokenColon           = 0x1A
+	TokenNullCoalescing  = 0x1B
+	TokenDotDot          = 0x1C
 
 	// Comparison Tokens
 	TokenLessThan     = 0x20
@@ -55,8 +60,81 @@ const (
 	TokenPrint    = 0x8A
 	TokenAnd      = 0x8B
 	TokenOr       = 0x8C
+	TokenSwitch   = 0x8D
+	TokenCase     = 0x8E
+	TokenDefault  = 0x8F
+	TokenEach     = 0x90
+	TokenIn       = 0x91
+	TokenStep     = 0x92
+	TokenYield    = 0x93
+	TokenSpawn    = 0x94
+	TokenBreak    = 0x95
+	TokenContinue = 0x96
 )
 
+// The names of the token types, for people and tools that can't make sense of the numbers
+var tokenNames = map[int]string{
+	TokenPlus:            "Plus",
+	TokenMinus:           "Minus",
+	TokenMult:            "Mult",
+	TokenDivision:        "Division",
+	TokenModulo:          "Modulo",
+	TokenExponentation:   "Exponentation",
+	TokenEqual:           "Equal",
+	TokenComma:           "Comma",
+	TokenLeftParentheses: "LeftParentheses",
+	TokenRightParenteses: "RightParenteses",
+	TokenLeftBrace:       "LeftBrace",
+	TokenRightBrace:      "RightBrace",
+	TokenEOF:             "EOF",
+	TokenSemiColon:       "SemiColon",
+	TokenNegation:        "Negation",
+	TokenQuestion:        "Question",
+	TokenColon:           "Colon",
+	TokenNullCoalescing:  "NullCoalescing",
+	TokenDotDot:          "DotDot",
+	TokenLessThan:        "LessThan",
+	TokenGreaterThan:     "GreaterThan",
+	TokenLessEqual:       "LessEqual",
+	TokenGreaterEqual:    "GreaterEqual",
+	TokenEqualEqual:      "EqualEqual",
+	TokenNotEqualTo:      "NotEqualTo",
+	TokenIdentifier:      "Identifier",
+	TokenString:          "String",
+	TokenNumber:          "Number",
+	TokenNull:            "Null",
+	TokenLet:             "Let",
+	TokenTrue:            "True",
+	TokenFalse:           "False",
+	TokenIf:              "If",
+	TokenElse:            "Else",
+	TokenFunction:        "Function",
+	TokenFor:             "For",
+	TokenWhile:           "While",
+	TokenReturn:          "Return",
+	TokenPrint:           "Print",
+	TokenAnd:             "And",
+	TokenOr:              "Or",
+	TokenSwitch:          "Switch",
+	TokenCase:            "Case",
+	TokenDefault:         "Default",
+	TokenEach:            "Each",
+	TokenIn:              "In",
+	TokenStep:            "Step",
+	TokenYield:           "Yield",
+	TokenSpawn:           "Spawn",
+	TokenBreak:           "Break",
+	TokenContinue:        "Continue",
+}
+
+// TokenName gives the name of a token type, the name of its constant without "Token"
+func TokenName(tokenType int) string {
+	if name, ok := tokenNames[tokenType]; ok {
+		return name
+	}
+	return fmt.Sprintf("Desconocido(0x%X)", tokenType)
+}
+
 var keywords = map[string]int{
 	"nulo":      TokenNull,
 	"var":       TokenLet,
@@ -71,47 +149,103 @@ var keywords = map[string]int{
 	"servir":    TokenPrint,
 	"y":         TokenAnd,
 	"o":         TokenOr,
+	"segun":     TokenSwitch,
+	"caso":      TokenCase,
+	"otro":      TokenDefault,
+	"cada":      TokenEach,
+	"en":        TokenIn,
+	"paso":      TokenStep,
+	"producir":  TokenYield,
+	"lanzar":    TokenSpawn,
+	"romper":    TokenBreak,
+	"continuar": TokenContinue,
 }
 
-// A Token represents a token as interpreted by the lexer
+// A Token represents a token as interpreted by the lexer. Column counts characters
+// from 1, where the token starts in its line
 type Token struct {
 	TokenType int
 	Lexeme    string
 	Literal   interface{}
 	Line      int
+	Column    int
+}
+
+// A Comment is a "//" comment, up to the end of its line, or the "#!" line a program may
+// start with. Comments aren't tokens, the lexer keeps them apart for tools that need
+// them, such as the formatter
+type Comment struct {
+	Text   string
+	Line   int
+	Column int
 }
 
-// Scan control and progress variables
-var line, start, currentPosition, end int
+// Keywords lists the reserved words of the language, in alphabetical order
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
+}
+
+// Scan control and progress variables. Positions are indices into runes, lineStart is where the current line begins
+var line, lineStart, start, currentPosition, end int
+
+// Strings may span lines, so tokens remember the line they started in
+var startLine, startLineStart int
 var runes []rune
-var rawCommand string
 var tokens []Token
+var comments []Comment
+
+// Comments gives the comments found by the last call to GetTokens, in order
+func Comments() []Comment {
+	return comments
+}
 
 func (t Token) String() string {
-	return fmt.Sprintf("(0x%X) %v - %v", t.TokenType, t.Lexeme, t.Literal)
+	return fmt.Sprintf("(%v) %v - %v", TokenName(t.TokenType), t.Lexeme, t.Literal)
 }
 
 // GetTokens takes a command string, and returns an array of all the tokens identified.
 func GetTokens(command string) []Token {
 	tokens = []Token{}
+	comments = []Comment{}
 	line = 1
+	lineStart = 0
 	start = 0
 	currentPosition = 0
-	end = len(command)
 	runes = []rune(command)
-	rawCommand = command
+	end = len(runes)
+
+	skipShebang()
 
 	for !atEndOfCommand() {
 		start = currentPosition
+		startLine, startLineStart = line, lineStart
 
 		scanNextToken()
 	}
 
-	tokens = append(tokens, Token{TokenEOF, "~EOF~", nil, line})
+	tokens = append(tokens, Token{TokenEOF, "~EOF~", nil, line, currentPosition - lineStart + 1})
 
 	return tokens
 }
 
+// skipShebang skips the "#!" line that lets a program be run as an executable. Its
+// newline is left for the loop, so the lines that follow keep their numbers
+func skipShebang() {
+	if end < 2 || runes[0] != '#' || runes[1] != '!' {
+		return
+	}
+
+	for !atEndOfCommand() && peek() != '\n' {
+		currentPosition++
+	}
+	comments = append(comments, Comment{string(runes[:currentPosition]), 1, 1})
+}
+
 func scanNextToken() {
 	character := runes[currentPosition]
 	currentPosition++
@@ -153,6 +287,19 @@ func scanNextToken() {
 	case '!':
 		addTokenIfMatch('=', TokenNotEqualTo, TokenNegation)
 		break
+	case '?':
+		addTokenIfMatch('?', TokenNullCoalescing, TokenQuestion)
+		break
+	case ':':
+		addToken(TokenColon)
+		break
+	case '.':
+		if match('.') {
+			addToken(TokenDotDot)
+		} else {
+			errorHandler.RaiseErrorAt(errorHandler.CodeSyntaxError, "Se esperaba otro . para formar un rango", line, start-lineStart+1, 1, "[Preparado]", true)
+		}
+		break
 	case '=':
 		addTokenIfMatch('=', TokenEqualEqual, TokenEqual)
 		break
@@ -163,10 +310,11 @@ func scanNextToken() {
 		addTokenIfMatch('=', TokenGreaterEqual, TokenGreaterThan)
 		break
 	case '/':
-		if runes[currentPosition] == '/' { // This is a comment
+		if peek() == '/' { // This is a comment
 			for peek() != '\n' && !atEndOfCommand() {
 				currentPosition++
 			}
+			comments = append(comments, Comment{string(runes[start:currentPosition]), line, start - lineStart + 1})
 		} else {
 			addToken(TokenDivision)
 		}
@@ -179,7 +327,7 @@ func scanNextToken() {
 	case '\t':
 		break // eat whitespace
 	case '\n':
-		line++ // Mark line increase
+		newLine()
 		break
 	default:
 		if isDigit(character) {
@@ -187,7 +335,7 @@ func scanNextToken() {
 		} else if isAlpha(character) {
 			parseIdentifier()
 		} else {
-			errorHandler.RaiseError(errorHandler.CodeSyntaxError, fmt.Sprintf("Caracter desconocido: %c", character), line, "[Preparado]", true)
+			errorHandler.RaiseErrorAt(errorHandler.CodeSyntaxError, fmt.Sprintf("Caracter desconocido: %c", character), line, start-lineStart+1, 1, "[Preparado]", true)
 		}
 
 	}
@@ -206,8 +354,13 @@ func addTokenIfMatch(m rune, tokenIfMatch int, tokenElse int) {
 }
 
 func addTokenWithLiteral(TokenType int, Literal interface{}) {
-	Lexeme := rawCommand[start:currentPosition]
-	tokens = append(tokens, Token{TokenType, Lexeme, Literal, line})
+	Lexeme := string(runes[start:currentPosition])
+	tokens = append(tokens, Token{TokenType, Lexeme, Literal, startLine, start - startLineStart + 1})
+}
+
+func newLine() {
+	line++
+	lineStart = currentPosition
 }
 
 func match(m rune) bool {
@@ -261,7 +414,7 @@ func parseNumberLexeme() {
 		}
 	}
 
-	Literal, err := strconv.ParseFloat(rawCommand[start:currentPosition], 64)
+	Literal, err := strconv.ParseFloat(string(runes[start:currentPosition]), 64)
 	utilities.AssertError(err)
 
 	addTokenWithLiteral(TokenNumber, Literal)
@@ -272,7 +425,7 @@ func parseIdentifier() {
 		currentPosition++
 	}
 
-	possibleKeyword := keywords[rawCommand[start:currentPosition]]
+	possibleKeyword := keywords[string(runes[start:currentPosition])]
 
 	if possibleKeyword != 0 { // is 0 when the value is not in the map
 		addToken(possibleKeyword)
@@ -283,10 +436,10 @@ func parseIdentifier() {
 
 func parseStringLexeme() {
 	for peek() != '"' && !atEndOfCommand() {
-		if peek() == '\n' {
-			line++
-		}
 		currentPosition++
+		if runes[currentPosition-1] == '\n' {
+			newLine()
+		}
 	}
 
 	if atEndOfCommand() {
@@ -295,7 +448,7 @@ func parseStringLexeme() {
 	} else {
 		currentPosition++
 
-		Literal := rawCommand[start+1 : currentPosition-1]
+		Literal := string(runes[start+1 : currentPosition-1])
 		addTokenWithLiteral(TokenString, Literal)
 	}
 }
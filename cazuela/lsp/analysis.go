@@ -0,0 +1,420 @@
+package lsp
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"clase-mates-computacionales/cazuela/resolver"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+Works out what an editor needs to know about a document: its errors, and for every
+name in it, the declaration it refers to.
+
+Names are bound with the same scopes the resolver uses. What isn't found in any of
+them is a global, which may be declared anywhere in the document, even after the
+function that uses it, so globals are bound once the whole document has been seen.
+Names declared nowhere are reported by the resolver, as "cazuela check" does.
+
+The AST keeps no braces, so where each local can be named is worked out from those
+among the tokens.
+*/
+
+const (
+	kindVariable = iota
+	kindFunction
+	kindParameter
+)
+
+// A symbol is something declared in the document. Function is the function it was
+// declared in, nil at the top level. Scope is where a local can be named, nil for
+// globals, which can be named anywhere
+type symbol struct {
+	name       string
+	token      lexer.Token
+	kind       int
+	parameters []string
+	function   *symbol
+	scope      *textRange
+}
+
+// A reference is a place where a symbol is named, its declaration included
+type reference struct {
+	token  lexer.Token
+	symbol *symbol
+}
+
+type analysis struct {
+	diagnostics []errorHandler.Diagnostic
+	symbols     []*symbol
+	references  []reference
+	builtins    []reference
+}
+
+// Natives and constants defined by InitEnv, along with how they are called
+var builtins = map[string]string{
+	"pi":         "var pi",
+	"e":          "var e",
+	"siguiente":  "fn siguiente(generador)",
+	"canal":      "fn canal()",
+	"enviar":     "fn enviar(canal, valor)",
+	"recibir":    "fn recibir(canal)",
+	"cerrar":     "fn cerrar(canal)",
+	"esperar":    "fn esperar(tarea)",
+	"argumentos": "var argumentos",
+	"entorno":    "fn entorno(nombre)",
+	"salir":      "fn salir(codigo)",
+}
+
+type analyzer struct {
+	a        *analysis
+	scopes   []map[string]*symbol
+	globals  map[string]*symbol
+	pending  []lexer.Token
+	function *symbol
+
+	// The tokens of the document, the index of each one by where it starts, the innermost
+	// opening brace around each one, if any, and the closing brace of each opening one
+	tokens    []lexer.Token
+	indices   map[position]int
+	enclosing []int
+	closing   map[int]int
+}
+
+// analyze lexes, parses and binds the names of a document, collecting its errors
+func analyze(text string) *analysis {
+	a := &analysis{}
+
+	a.diagnostics = errorHandler.Collect(func() {
+		tokens := lexer.GetTokens(text)
+
+		// The parser stops at its first error, lexing errors only drop characters
+		errorHandler.HasFatalled = false
+		statements := parser.Parse(tokens)
+
+		// A program cut short by an error may well use names it declares further down
+		if !errorHandler.HasFatalled {
+			for _, name := range resolver.Undefined(statements, isBuiltin) {
+				errorHandler.RaiseErrorAt(errorHandler.CodeUndefinedVariable, fmt.Sprintf("Variable %v no definida", name.Lexeme), name.Line, name.Column, len([]rune(name.Lexeme)), "[Resolución]", true)
+			}
+		}
+
+		an := &analyzer{a: a, globals: make(map[string]*symbol)}
+		an.matchBraces(tokens)
+		an.statements(statements)
+		an.bindGlobals()
+	})
+
+	return a
+}
+
+func isBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// matchBraces pairs the braces among the tokens. Those left open close at the end of the document
+func (an *analyzer) matchBraces(tokens []lexer.Token) {
+	an.tokens = tokens
+	an.indices = make(map[position]int)
+	an.enclosing = make([]int, len(tokens))
+	an.closing = make(map[int]int)
+
+	var open []int
+	for i, t := range tokens {
+		an.indices[tokenRange(t).Start] = i
+
+		if t.TokenType == lexer.TokenRightBrace && len(open) > 0 {
+			an.closing[open[len(open)-1]] = i
+			open = open[:len(open)-1]
+		}
+
+		an.enclosing[i] = -1
+		if len(open) > 0 {
+			an.enclosing[i] = open[len(open)-1]
+		}
+
+		if t.TokenType == lexer.TokenLeftBrace {
+			open = append(open, i)
+		}
+	}
+
+	for _, i := range open {
+		an.closing[i] = len(tokens) - 1
+	}
+}
+
+// between is the range from where one token starts to where another one does
+func (an *analyzer) between(from int, to int) *textRange {
+	return &textRange{tokenRange(an.tokens[from]).Start, tokenRange(an.tokens[to]).Start}
+}
+
+// blockScope is where a local declared at token can be named: from there, or from the
+// start of its block when it is a function, which can be called before it is declared,
+// to the end of its block
+func (an *analyzer) blockScope(token lexer.Token, hoisted bool) *textRange {
+	i, ok := an.indices[tokenRange(token).Start]
+	if !ok || an.enclosing[i] < 0 {
+		return nil
+	}
+
+	open := an.enclosing[i]
+	if hoisted {
+		return an.between(open, an.closing[open])
+	}
+	return an.between(i, an.closing[open])
+}
+
+// bodyScope is the block that follows a token, such as the body of a function after its name
+func (an *analyzer) bodyScope(token lexer.Token) *textRange {
+	i, ok := an.indices[tokenRange(token).Start]
+	if !ok {
+		return nil
+	}
+
+	for ; i < len(an.tokens); i++ {
+		if an.tokens[i].TokenType == lexer.TokenLeftBrace {
+			return an.between(i, an.closing[i])
+		}
+	}
+	return nil
+}
+
+// declare declares a symbol, which is a local visible in scope unless it is at the top level
+func (an *analyzer) declare(token lexer.Token, kind int, scope *textRange) *symbol {
+	s := &symbol{name: token.Lexeme, token: token, kind: kind, function: an.function}
+	an.a.symbols = append(an.a.symbols, s)
+	an.a.references = append(an.a.references, reference{token, s})
+
+	if len(an.scopes) == 0 {
+		if _, declared := an.globals[s.name]; !declared {
+			an.globals[s.name] = s
+		}
+	} else {
+		an.scopes[len(an.scopes)-1][s.name] = s
+		s.scope = scope
+	}
+
+	return s
+}
+
+func (an *analyzer) use(token lexer.Token) {
+	for i := len(an.scopes) - 1; i >= 0; i-- {
+		if s, ok := an.scopes[i][token.Lexeme]; ok {
+			an.a.references = append(an.a.references, reference{token, s})
+			return
+		}
+	}
+
+	an.pending = append(an.pending, token)
+}
+
+// bindGlobals binds the names not found in any scope
+func (an *analyzer) bindGlobals() {
+	for _, token := range an.pending {
+		if s, ok := an.globals[token.Lexeme]; ok {
+			an.a.references = append(an.a.references, reference{token, s})
+		} else if isBuiltin(token.Lexeme) {
+			an.a.builtins = append(an.a.builtins, reference{token, nil})
+		}
+	}
+}
+
+func (an *analyzer) beginScope() {
+	an.scopes = append(an.scopes, make(map[string]*symbol))
+}
+
+func (an *analyzer) endScope() {
+	an.scopes = an.scopes[:len(an.scopes)-1]
+}
+
+func (an *analyzer) statements(stmts []parser.Stmt) {
+	for _, s := range stmts {
+		an.statement(s)
+	}
+}
+
+func (an *analyzer) statement(s parser.Stmt) {
+	switch v := s.(type) {
+	case parser.Statement:
+		an.expression(v.Expr)
+	case parser.Print:
+		an.expression(v.Expr)
+	case parser.Declaration:
+		an.expression(v.Initializer)
+		an.declare(v.Name, kindVariable, an.blockScope(v.Name, false))
+	case parser.Block:
+		an.beginScope()
+		an.statements(v.Statements)
+		an.endScope()
+	case parser.If:
+		an.expression(v.Condition)
+		an.statement(v.ThenBranch)
+		an.statement(v.ElseBranch)
+	case parser.While:
+		an.expression(v.Condition)
+		an.statement(v.Body)
+		an.expression(v.Increment)
+	case parser.FnDecl:
+		an.fnDecl(v)
+	case parser.ForEach:
+		an.expression(v.Iterable)
+		an.beginScope()
+		an.declare(v.Variable, kindVariable, an.forEachScope(v))
+		an.statement(v.Body)
+		an.endScope()
+	case parser.Switch:
+		an.expression(v.Subject)
+		for _, c := range v.Cases {
+			an.expressions(c.Patterns)
+			an.statement(c.Body)
+		}
+		an.statement(v.Default)
+	case parser.ReturnStmt:
+		an.expression(v.Value)
+	case parser.YieldStmt:
+		an.expression(v.Value)
+	}
+}
+
+// forEachScope is where the variable of a "por cada" can be named: its body, which may
+// be a single statement without braces
+func (an *analyzer) forEachScope(v parser.ForEach) *textRange {
+	if _, isBlock := v.Body.(parser.Block); isBlock {
+		return an.bodyScope(v.Variable)
+	}
+
+	span, ok := parser.SpanOf(v.Body)
+	if !ok {
+		return nil
+	}
+	return &textRange{tokenRange(v.Variable).Start, position{span.End.Line - 1, span.End.Column - 1}}
+}
+
+// fnDecl declares a function and binds its body, where its parameters are visible
+func (an *analyzer) fnDecl(v parser.FnDecl) {
+	fn := an.declare(v.Name, kindFunction, an.blockScope(v.Name, true))
+	body := an.bodyScope(v.Name)
+
+	enclosing := an.function
+	an.function = fn
+	an.beginScope()
+
+	// The parser reserves an empty first parameter
+	for _, parameter := range v.Parameters {
+		if parameter.Lexeme != "" {
+			an.declare(parameter, kindParameter, body)
+			fn.parameters = append(fn.parameters, parameter.Lexeme)
+		}
+	}
+	an.statements(v.Body)
+
+	an.endScope()
+	an.function = enclosing
+}
+
+func (an *analyzer) expressions(exprs []parser.Expression) {
+	for _, e := range exprs {
+		an.expression(e)
+	}
+}
+
+func (an *analyzer) expression(expr parser.Expression) {
+	switch v := expr.(type) {
+	case parser.VariableExpression:
+		an.use(v.Name)
+	case parser.AssignmentExpression:
+		an.expression(v.Value)
+		an.use(v.Name)
+	case parser.GroupingExpression:
+		an.expression(v.Expression)
+	case parser.UnaryExpression:
+		an.expression(v.Right)
+	case parser.BinaryExpression:
+		an.expression(v.Left)
+		an.expression(v.Right)
+	case parser.LogicalExpression:
+		an.expression(v.Left)
+		an.expression(v.Right)
+	case parser.ConditionalExpression:
+		an.expression(v.Condition)
+		an.expression(v.ThenBranch)
+		an.expression(v.ElseBranch)
+	case parser.RangeExpression:
+		an.expression(v.Start)
+		an.expression(v.End)
+		an.expression(v.Step)
+	case parser.CallExpression:
+		an.call(v)
+	case parser.SpawnExpression:
+		an.call(v.Call)
+	}
+}
+
+func (an *analyzer) call(v parser.CallExpression) {
+	an.expression(v.Callee)
+	an.expressions(v.Arguments)
+}
+
+// at finds the name at a position, giving false when there is none
+func (a *analysis) at(p position) (reference, bool) {
+	for _, references := range [][]reference{a.references, a.builtins} {
+		for _, r := range references {
+			if contains(tokenRange(r.token), p) {
+				return r, true
+			}
+		}
+	}
+	return reference{}, false
+}
+
+// referencesTo lists the places where a symbol is named, in the order they appear
+func (a *analysis) referencesTo(s *symbol, includeDeclaration bool) []lexer.Token {
+	var tokens []lexer.Token
+	for _, r := range a.references {
+		if r.symbol == s && (includeDeclaration || r.token != s.token) {
+			tokens = append(tokens, r.token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Line != tokens[j].Line {
+			return tokens[i].Line < tokens[j].Line
+		}
+		return tokens[i].Column < tokens[j].Column
+	})
+	return tokens
+}
+
+// describe is what hovering over a symbol shows
+func (s *symbol) describe() string {
+	switch s.kind {
+	case kindFunction:
+		return fmt.Sprintf("fn %v(%v)", s.name, strings.Join(s.parameters, ", "))
+	case kindParameter:
+		return fmt.Sprintf("parámetro %v de fn %v", s.name, s.function.name)
+	}
+	return "var " + s.name
+}
+
+// tokenRange is where a token is, lexer positions count from 1 and LSP ones from 0
+func tokenRange(t lexer.Token) textRange {
+	start := position{t.Line - 1, t.Column - 1}
+	return textRange{start, position{start.Line, start.Character + len([]rune(t.Lexeme))}}
+}
+
+func contains(r textRange, p position) bool {
+	return p.Line == r.Start.Line && p.Character >= r.Start.Character && p.Character <= r.End.Character
+}
+
+// within is contains for ranges that may span lines
+func within(r textRange, p position) bool {
+	return !before(p, r.Start) && !before(r.End, p)
+}
+
+func before(a position, b position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
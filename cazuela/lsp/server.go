@@ -0,0 +1,360 @@
+package lsp
+
+import (
+	"bufio"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/lexer"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/*
+A Language Server Protocol server, so editors can show the errors of Cazuela programs
+as they are written and move around them. It supports:
+
+	diagnostics      errors from the lexer and parser, and names declared nowhere
+	definition       where a variable, parameter or function is declared
+	references       every place one is named
+	hover            how a function is called, or what a name is
+	documentSymbol   the variables and functions declared in the document
+	completion       keywords and the names that can be used where the cursor is
+
+Documents are sent whole on every change and analyzed again from scratch, Cazuela
+programs are small enough for that. Characters are counted as Unicode code points,
+which only differs from what the protocol expects past characters outside the Basic
+Multilingual Plane.
+*/
+
+// A Server talks to one client through in and out, until it exits
+type Server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	documents map[string]*document
+}
+
+type document struct {
+	text     string
+	analysis *analysis
+}
+
+// NewServer creates a server reading messages from in and writing to out
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		documents: make(map[string]*document),
+	}
+}
+
+// Serve answers messages until the client says exit or goes away
+func (s *Server) Serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, failure := s.handle(msg)
+		if result == nil && failure == nil {
+			result = json.RawMessage("null")
+		}
+
+		// Notifications get no answer
+		if msg.ID != nil {
+			writeMessage(s.out, message{ID: msg.ID, Result: result, Error: failure})
+		}
+	}
+}
+
+// handle answers a message, with its result or why it failed
+func (s *Server) handle(msg message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"completionProvider":     map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "cazuela"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if len(params.ContentChanges) > 0 {
+			s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.publish(params.TextDocument.URI, []diagnostic{})
+		return nil, nil
+	case "textDocument/definition":
+		return s.withPosition(msg, s.definition)
+	case "textDocument/references":
+		return s.withPosition(msg, s.references)
+	case "textDocument/hover":
+		return s.withPosition(msg, s.hover)
+	case "textDocument/completion":
+		return s.withPosition(msg, s.completion)
+	case "textDocument/documentSymbol":
+		var params documentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return s.documentSymbols(params.TextDocument.URI), nil
+	}
+
+	if msg.ID == nil || strings.HasPrefix(msg.Method, "$/") {
+		return nil, nil
+	}
+	return nil, &responseError{codeMethodNotFound, fmt.Sprintf("Método no soportado: %v", msg.Method)}
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{codeInvalidParams, err.Error()}
+}
+
+// withPosition decodes the parameters of a request about a position in a document.
+// There are no results for documents that were never opened
+func (s *Server) withPosition(msg message, answer func(string, *document, positionParams) interface{}) (interface{}, *responseError) {
+	var params positionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, invalidParams(err)
+	}
+
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil, nil
+	}
+
+	return answer(params.TextDocument.URI, doc, params), nil
+}
+
+// update analyzes a document again and tells the client about its errors
+func (s *Server) update(uri string, text string) {
+	doc := &document{text, analyze(text)}
+	s.documents[uri] = doc
+	s.publish(uri, doc.diagnostics())
+}
+
+func (s *Server) publish(uri string, diagnostics []diagnostic) {
+	writeMessage(s.out, message{
+		Method: "textDocument/publishDiagnostics",
+		Params: mustMarshal(publishDiagnosticsParams{uri, diagnostics}),
+	})
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	content, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return content
+}
+
+// diagnostics turns the errors of the document into what the client shows. Those that
+// know the piece of the line they are about cover it, the rest cover the code in the line
+func (d *document) diagnostics() []diagnostic {
+	lines := strings.Split(d.text, "\n")
+	result := []diagnostic{}
+
+	for _, e := range d.analysis.diagnostics {
+		// The parser follows its errors with a general one that has no line
+		if e.Line < 1 && len(d.analysis.diagnostics) > 1 {
+			continue
+		}
+
+		line := e.Line - 1
+		if line < 0 {
+			line = 0
+		}
+		if line >= len(lines) {
+			line = len(lines) - 1
+		}
+
+		severity := severityError
+		if e.Warning {
+			severity = severityWarning
+		}
+
+		result = append(result, diagnostic{
+			Range:    errorRange(e, line, lines[line]),
+			Severity: severity,
+			Source:   "cazuela",
+			Message:  describeError(e),
+		})
+	}
+
+	return result
+}
+
+// errorRange is where an error is, on a line of the document counting from 0
+func errorRange(e errorHandler.Diagnostic, line int, text string) textRange {
+	if e.Column > 0 {
+		start := position{line, e.Column - 1}
+		return textRange{start, position{line, start.Character + e.Length}}
+	}
+
+	characters := []rune(strings.TrimRight(text, " \t\r"))
+	indentation := len(characters) - len([]rune(strings.TrimLeft(string(characters), " \t")))
+	return textRange{position{line, indentation}, position{line, len(characters)}}
+}
+
+// describeError tells what went wrong. The context is either the phase, in brackets,
+// or the text the parser stopped at
+func describeError(e errorHandler.Diagnostic) string {
+	if e.Context == "" || strings.HasPrefix(e.Context, "[") {
+		return e.Message
+	}
+	return fmt.Sprintf("%v (en '%v')", e.Message, e.Context)
+}
+
+func (s *Server) definition(uri string, doc *document, params positionParams) interface{} {
+	r, ok := doc.analysis.at(params.Position)
+	if !ok || r.symbol == nil {
+		return nil
+	}
+	return location{uri, tokenRange(r.symbol.token)}
+}
+
+func (s *Server) references(uri string, doc *document, params positionParams) interface{} {
+	r, ok := doc.analysis.at(params.Position)
+	if !ok || r.symbol == nil {
+		return nil
+	}
+
+	locations := []location{}
+	for _, token := range doc.analysis.referencesTo(r.symbol, params.Context.IncludeDeclaration) {
+		locations = append(locations, location{uri, tokenRange(token)})
+	}
+	return locations
+}
+
+func (s *Server) hover(uri string, doc *document, params positionParams) interface{} {
+	r, ok := doc.analysis.at(params.Position)
+	if !ok {
+		return nil
+	}
+
+	description := builtins[r.token.Lexeme]
+	if r.symbol != nil {
+		description = r.symbol.describe()
+	}
+
+	return hover{markupContent{"markdown", "```cazuela\n" + description + "\n```"}, tokenRange(r.token)}
+}
+
+// completion offers every keyword and the names that can be used at the position: the
+// globals, wherever they are declared, and the locals in scope there
+func (s *Server) completion(uri string, doc *document, params positionParams) interface{} {
+	items := []completionItem{}
+	seen := make(map[string]bool)
+
+	// Of those with the same name, the local declared further in shadows the rest
+	visible := make(map[string]*symbol)
+	for _, sym := range doc.analysis.symbols {
+		if sym.scope != nil && !within(*sym.scope, params.Position) {
+			continue
+		}
+		if shadowed, ok := visible[sym.name]; ok && (sym.scope == nil || (shadowed.scope != nil && before(sym.scope.Start, shadowed.scope.Start))) {
+			continue
+		}
+		visible[sym.name] = sym
+	}
+
+	for _, sym := range doc.analysis.symbols {
+		if visible[sym.name] != sym {
+			continue
+		}
+		seen[sym.name] = true
+
+		kind := completionKindVariable
+		if sym.kind == kindFunction {
+			kind = completionKindFunction
+		}
+		items = append(items, completionItem{sym.name, kind, sym.describe()})
+	}
+
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !seen[name] {
+			kind := completionKindVariable
+			if strings.HasPrefix(builtins[name], "fn ") {
+				kind = completionKindFunction
+			}
+			items = append(items, completionItem{name, kind, builtins[name]})
+		}
+	}
+
+	for _, keyword := range lexer.Keywords() {
+		items = append(items, completionItem{keyword, completionKindKeyword, ""})
+	}
+
+	return items
+}
+
+// documentSymbols lists the variables and functions declared in the document, along
+// with the function that holds each one, if any
+func (s *Server) documentSymbols(uri string) interface{} {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return nil
+	}
+
+	symbols := []symbolInformation{}
+	for _, sym := range doc.analysis.symbols {
+		if sym.kind == kindParameter {
+			continue
+		}
+
+		kind := symbolKindVariable
+		if sym.kind == kindFunction {
+			kind = symbolKindFunction
+		}
+
+		container := ""
+		if sym.function != nil {
+			container = sym.function.name
+		}
+
+		symbols = append(symbols, symbolInformation{sym.name, kind, location{uri, tokenRange(sym.token)}, container})
+	}
+
+	return symbols
+}
@@ -0,0 +1,94 @@
+package lsp
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const program = `fn suma(a, b) {
+    var total = a + b;
+    por cada i en 0..3 {
+        total = total + i;
+    }
+    sazonar total;
+}
+
+var x = suma(1, 2);
+servir x + z;
+`
+
+// labels lists what completion offers at a position, leaving out the keywords
+func labels(t *testing.T, text string, line int, character int) map[string]bool {
+	t.Helper()
+
+	s := NewServer(strings.NewReader(""), ioutil.Discard)
+	doc := &document{text, analyze(text)}
+	items := s.completion("archivo.caz", doc, positionParams{Position: position{line, character}}).([]completionItem)
+
+	result := make(map[string]bool)
+	for _, item := range items {
+		if item.Kind != completionKindKeyword {
+			result[item.Label] = true
+		}
+	}
+	return result
+}
+
+func TestCompletionScope(t *testing.T) {
+	cases := []struct {
+		name     string
+		position position
+		offered  []string
+		hidden   []string
+	}{
+		{"nivel superior", position{8, 0}, []string{"suma", "x", "canal"}, []string{"a", "b", "total", "i"}},
+		{"cuerpo de la función", position{1, 4}, []string{"suma", "x", "a", "b"}, []string{"total", "i"}},
+		{"después de declarar", position{2, 4}, []string{"a", "b", "total"}, []string{"i"}},
+		{"dentro del ciclo", position{3, 8}, []string{"a", "b", "total", "i"}, nil},
+		{"después del ciclo", position{5, 4}, []string{"total"}, []string{"i"}},
+	}
+
+	for _, c := range cases {
+		offered := labels(t, program, c.position.Line, c.position.Character)
+		for _, name := range c.offered {
+			if !offered[name] {
+				t.Errorf("%v: no se ofrece %v", c.name, name)
+			}
+		}
+		for _, name := range c.hidden {
+			if offered[name] {
+				t.Errorf("%v: se ofrece %v, que no está al alcance", c.name, name)
+			}
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	cases := []struct {
+		text    string
+		message string
+		at      textRange
+	}{
+		{program, "Variable z no definida", textRange{position{9, 11}, position{9, 12}}},
+		{"var x = 1;\n  servir x +;\n", "", textRange{position{1, 12}, position{1, 13}}},
+		{"var x = 1 # 2;\n", "Caracter desconocido: #", textRange{position{0, 10}, position{0, 11}}},
+	}
+
+	for _, c := range cases {
+		doc := &document{c.text, analyze(c.text)}
+		diagnostics := doc.diagnostics()
+		if len(diagnostics) == 0 {
+			t.Errorf("%q no tiene diagnósticos", c.text)
+			continue
+		}
+
+		d := diagnostics[0]
+		if c.message != "" && !strings.HasPrefix(d.Message, c.message) {
+			t.Errorf("%q: se esperaba %q, se obtuvo %q", c.text, c.message, d.Message)
+		}
+		if d.Range != c.at || d.Severity != severityError {
+			t.Errorf("%q: el diagnóstico %q está en %+v con severidad %d, se esperaba %+v", c.text, d.Message, d.Range, d.Severity, c.at)
+		}
+	}
+}
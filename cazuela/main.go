@@ -4,50 +4,244 @@ import (
 	"clase-mates-computacionales/cazuela/errorHandler"
 	"clase-mates-computacionales/cazuela/interpreter"
 	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/optimizer"
 	"clase-mates-computacionales/cazuela/parser"
-	"clase-mates-computacionales/utilities"
+	"clase-mates-computacionales/cazuela/sandbox"
+	"clase-mates-computacionales/cazuela/values"
+	"clase-mates-computacionales/cazuela/vm"
+	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 )
 
+// A command is one of the things cazuela does, chosen by the first argument. It
+// gives back the code to exit with, one of those of errorHandler
+type command struct {
+	name        string
+	arguments   string
+	description string
+	run         func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "[opciones] archivo|- [argumentos]", "ejecuta un programa, o el que llegue por la entrada estándar con -, que recibe los argumentos que siguen en \"argumentos\"", runCommand},
+		{"check", "archivos", "busca errores en programas sin ejecutarlos", checkCommand},
+		{"fmt", "[-w] [-check] archivos", "da formato a programas", fmtCommand},
+		{"ast", "[-json] archivo", "muestra el árbol que resulta de leer un programa", astCommand},
+		{"tokens", "[-json] archivo", "muestra los tokens de un programa", tokensCommand},
+		{"test", "[opciones] [archivos o carpetas]", "ejecuta programas comparando lo que sirven con su archivo .salida", testCommand},
+		{"repl", "[opciones]", "abre la consola interactiva", replCommand},
+		{"depurar", "archivo", "ejecuta un programa paso a paso", debugCommand},
+		{"dap", "", "depura programas desde un editor con el Debug Adapter Protocol", dapCommand},
+		{"lsp", "", "analiza programas desde un editor con el Language Server Protocol", lspCommand},
+		{"ayuda", "", "muestra esta ayuda", helpCommand},
+	}
+}
+
 func main() {
-	args := os.Args
-	interpreter.InitEnv()
-	if len(args) > 2 {
-		fmt.Println("Uso: cazuela [archivo]")
-		errorHandler.RaiseErrorWithCode(errorHandler.CodeTooManyArguments)
-	} else if len(args) == 2 {
-		file := utilities.LoadFile(args[1])
-		execute(file)
+	args := os.Args[1:]
+
+	if len(args) == 0 {
+		os.Exit(replCommand(nil))
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			os.Exit(c.run(args[1:]))
+		}
+	}
+
+	// Without a command the arguments are those of run, as in "cazuela --vm archivo.caz"
+	os.Exit(runCommand(args))
+}
+
+func helpCommand(args []string) int {
+	usage()
+	return errorHandler.CodeAllGood
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Uso: cazuela [comando] [opciones] [argumentos]")
+	fmt.Fprintln(os.Stderr, "\nSin comando, cazuela archivo ejecuta el archivo y cazuela a secas abre la consola.")
+	fmt.Fprintln(os.Stderr, "\nComandos:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8v %v\n", c.name, c.arguments)
+		fmt.Fprintf(os.Stderr, "           %v\n", c.description)
+	}
+	fmt.Fprintln(os.Stderr, "\ncazuela comando -h muestra las opciones de cada uno.")
+}
+
+// newFlags creates the flags of a command
+func newFlags(name string, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Uso: cazuela %v %v\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags reads the flags of a command, giving the code to exit with if they were wrong or -h was asked for
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return errorHandler.CodeAllGood, false
+	}
+	if err != nil {
+		return errorHandler.CodeInvalidUsage, false
+	}
+	return errorHandler.CodeAllGood, true
+}
+
+// invalidUsage reports a command used the wrong way
+func invalidUsage(flags *flag.FlagSet) int {
+	flags.Usage()
+	return errorHandler.CodeInvalidUsage
+}
+
+// The options of the commands that run programs
+var options struct {
+	vm        bool
+	optimize  bool
+	maxSteps  int64
+	timeout   time.Duration
+	maxString int
+	natives   string
+	maxDepth  int
+}
+
+// runFlags creates the flags of a command that runs programs
+func runFlags(name string, arguments string) *flag.FlagSet {
+	flags := newFlags(name, arguments)
+	flags.BoolVar(&options.vm, "vm", false, "ejecutar con la máquina virtual de bytecode en lugar del intérprete")
+	flags.BoolVar(&options.optimize, "optimize", false, "simplificar el programa antes de ejecutarlo")
+	flags.Int64Var(&options.maxSteps, "max-steps", 0, "cuántos pasos puede dar el programa, 0 para no limitarlos")
+	flags.DurationVar(&options.timeout, "timeout", 0, "cuánto tiempo puede correr el programa, 0 para no limitarlo")
+	flags.IntVar(&options.maxString, "max-string", 0, "cuántos bytes puede tener una cadena, 0 para no limitarlos")
+	flags.StringVar(&options.natives, "natives", "", "funciones nativas permitidas, separadas por comas; todas si se omite")
+	flags.IntVar(&options.maxDepth, "max-depth", interpreter.MaxCallDepth, "cuántas llamadas anidadas se permiten antes de detener el programa")
+	return flags
+}
+
+// applyRunFlags sets up both engines with the options given, once the flags have been read
+func applyRunFlags(flags *flag.FlagSet) {
+	interpreter.MaxCallDepth = options.maxDepth
+	vm.MaxCallDepth = options.maxDepth
+
+	limits := sandbox.Limits{MaxSteps: options.maxSteps, Timeout: options.timeout, MaxStringLength: options.maxString}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "natives" {
+			limits.Natives = strings.Split(options.natives, ",")
+		}
+	})
+	interpreter.Limits = limits
+	vm.Limits = limits
+}
+
+// initEnv prepares the global environment of the engine chosen
+func initEnv() {
+	if options.vm {
+		vm.InitEnv()
 	} else {
-		startLineInterpreter()
+		interpreter.InitEnv()
 	}
 }
 
-func startLineInterpreter() {
-	errorHandler.IgnoreFatals = true
-	interpreter.ShouldPrintAllExpressions = true
-	for {
-		fmt.Print("<Cazuela># ")
-		input := utilities.GetConsoleInput()
-		execute(input)
+// The path that stands for the standard input, so programs can be piped in
+const stdinPath = "-"
+
+// loadSource reads a program, from the standard input if its path is "-", reporting it if it can't
+func loadSource(path string) (string, bool) {
+	var data []byte
+	var err error
+	if path == stdinPath {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+
+	if err != nil {
+		errorHandler.RaiseError(errorHandler.CodeFileError, err.Error(), 0, "["+path+"]", false)
+		return "", false
 	}
+	return string(data), true
 }
 
-func execute(command string) {
+// runCommand runs a program, or opens the REPL if there is none. Fatal errors and
+// "salir" end the program with their code on their own
+func runCommand(args []string) int {
+	flags := runFlags("run", "[opciones] archivo|- [argumentos]")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	applyRunFlags(flags)
+
+	if flags.NArg() == 0 {
+		return repl()
+	}
+
+	source, ok := loadSource(flags.Arg(0))
+	if !ok {
+		return errorHandler.CodeFileError
+	}
+
+	values.Arguments = flags.Args()[1:]
+
+	initEnv()
+	execute(context.Background(), source)
+	return errorHandler.ExitCode()
+}
+
+func replCommand(args []string) int {
+	flags := runFlags("repl", "[opciones]")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	applyRunFlags(flags)
+
+	if flags.NArg() > 0 {
+		return invalidUsage(flags)
+	}
+	return repl()
+}
+
+func repl() int {
+	initEnv()
+	startLineInterpreter()
+	return errorHandler.CodeAllGood
+}
+
+func execute(ctx context.Context, command string) {
+	// An error in the previous command must not keep this one from running
+	errorHandler.HasFatalled = false
+	errorHandler.HasExited = false
+
 	tokens := lexer.GetTokens(command)
 
 	if errorHandler.HasFatalled {
-		errorHandler.HasFatalled = false
 		return
 	}
 
 	statements := parser.Parse(tokens)
 
 	if errorHandler.HasFatalled {
-		errorHandler.HasFatalled = false
 		return
 	}
 
-	interpreter.Interpret(statements)
+	if options.optimize {
+		statements = optimizer.Optimize(statements)
+	}
+
+	if options.vm {
+		vm.Interpret(ctx, statements)
+	} else {
+		interpreter.Interpret(ctx, statements)
+	}
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestScripts runs every program in the tests folder on both engines, with and without
+// the optimizer, comparing what each one writes with its .salida
+func TestScripts(t *testing.T) {
+	programs, err := findTests("tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(programs) == 0 {
+		t.Fatal("no hay programas en tests")
+	}
+
+	errorHandler.IgnoreFatals = true
+	defer func() {
+		errorHandler.IgnoreFatals = false
+		options.vm, options.optimize = false, false
+	}()
+
+	engines := []struct {
+		name     string
+		vm       bool
+		optimize bool
+	}{
+		{"interprete", false, false},
+		{"vm", true, false},
+		{"interprete+optimize", false, true},
+		{"vm+optimize", true, true},
+	}
+
+	for _, engine := range engines {
+		for _, program := range programs {
+			engine, program := engine, program
+			t.Run(engine.name+"/"+filepath.Base(program), func(t *testing.T) {
+				source, err := ioutil.ReadFile(program)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected, err := ioutil.ReadFile(expectedOutput(program))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				options.vm, options.optimize = engine.vm, engine.optimize
+				got := normalizeNewlines(captureOutput(string(source)))
+				want := normalizeNewlines(string(expected))
+				if got != want {
+					t.Errorf("se esperaba:\n%v\nse obtuvo:\n%v", want, got)
+				}
+			})
+		}
+	}
+}
+
+// TestGeneratorsAreReleased checks that no generator is left waiting to be resumed once a
+// program is over, whether a loop left it early or nothing asked it for every value
+func TestGeneratorsAreReleased(t *testing.T) {
+	const naturals = "fn naturales() { var n = 0; mientras (verdadero) { producir n; n = n + 1; } }\n"
+	programs := []string{
+		"por cada x en naturales() { si (x == 2) romper; }",
+		"fn primero() { por cada x en naturales() sazonar x; } primero();",
+		"fn pares(g) { por cada x en g { si (x % 2 == 0) producir x; } } por cada p en pares(naturales()) { si (p > 4) romper; }",
+		"var g = naturales(); siguiente(g); siguiente(g);",
+	}
+
+	errorHandler.IgnoreFatals = true
+	defer func() {
+		errorHandler.IgnoreFatals = false
+		options.vm = false
+	}()
+
+	before := runtime.NumGoroutine()
+	for _, vm := range []bool{false, true} {
+		options.vm = vm
+		for _, program := range programs {
+			for i := 0; i < 100; i++ {
+				captureOutput(naturals + program)
+			}
+		}
+	}
+
+	// Bodies left waiting are unwound when the run ends, which takes them a moment
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("quedaron %d goroutines de más", after-before)
+	}
+}
+
+// TestFmtExitCodes checks fmt tells apart files to format from files it can't format
+func TestFmtExitCodes(t *testing.T) {
+	defer func() {
+		errorHandler.IgnoreFatals, errorHandler.Output = false, os.Stdout
+	}()
+
+	dir, err := ioutil.TempDir("", "cazuela")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"formateado.caz":  "servir 1 + 2;\n",
+		"sin_formato.caz": "servir 1+2;",
+		"con_errores.caz": "servir (1;",
+		"no_existe.caz":   "",
+	}
+	for name, source := range files {
+		if source != "" {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	cases := []struct {
+		args []string
+		code int
+	}{
+		{nil, errorHandler.CodeInvalidUsage},
+		{[]string{"-check", path("formateado.caz")}, errorHandler.CodeAllGood},
+		{[]string{"-check", path("sin_formato.caz")}, errorHandler.CodeUnformatted},
+		{[]string{"-check", path("con_errores.caz")}, errorHandler.CodeSyntaxError},
+		{[]string{"-check", path("con_errores.caz"), path("sin_formato.caz")}, errorHandler.CodeSyntaxError},
+		{[]string{"-check", path("no_existe.caz")}, errorHandler.CodeFileError},
+	}
+
+	for _, c := range cases {
+		errorHandler.HasFatalled = false
+		if code := fmtCommand(c.args); code != c.code {
+			t.Errorf("fmt %v terminó con el código %X, se esperaba %X", c.args, code, c.code)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package optimizer
+
+import (
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"math"
+)
+
+/*
+An optional pass that simplifies the AST before it runs, without changing what the
+program prints:
+
+  - operations between literals are folded into a single literal
+  - "si", "?:", "y", "o" and "??" with a literal deciding them keep only the branch taken
+  - statements after "sazonar", "romper" or "continuar" in the same block are dropped
+  - loops whose condition is always true get no condition at all, so it is never evaluated
+
+Operations that would fail at run time are left alone, so the error is still reported
+where it happens.
+*/
+
+// Optimize returns a simplified copy of the AST
+func Optimize(stmts []parser.Stmt) []parser.Stmt {
+	return optimizeStatements(stmts)
+}
+
+func optimizeStatements(stmts []parser.Stmt) []parser.Stmt {
+	optimized := make([]parser.Stmt, 0, len(stmts))
+
+	for _, s := range stmts {
+		s = optimizeStatement(s)
+		optimized = append(optimized, s)
+
+		if completesAbruptly(s) {
+			break
+		}
+	}
+
+	return optimized
+}
+
+func completesAbruptly(s parser.Stmt) bool {
+	switch s.(type) {
+	case parser.ReturnStmt, parser.BreakStmt, parser.ContinueStmt:
+		return true
+	}
+	return false
+}
+
+func optimizeStatement(s parser.Stmt) parser.Stmt {
+	if v, ok := s.(parser.Statement); ok {
+		v.Expr = optimizeExpression(v.Expr)
+		return v
+	} else if v, ok := s.(parser.Print); ok {
+		v.Expr = optimizeExpression(v.Expr)
+		return v
+	} else if v, ok := s.(parser.Declaration); ok {
+		v.Initializer = optimizeExpression(v.Initializer)
+		return v
+	} else if v, ok := s.(parser.Block); ok {
+		return parser.Block{Statements: optimizeStatements(v.Statements)}
+	} else if v, ok := s.(parser.If); ok {
+		return optimizeIf(v)
+	} else if v, ok := s.(parser.While); ok {
+		return optimizeWhile(v)
+	} else if v, ok := s.(parser.FnDecl); ok {
+		v.Body = optimizeStatements(v.Body)
+		return v
+	} else if v, ok := s.(parser.ForEach); ok {
+		v.Iterable = optimizeExpression(v.Iterable)
+		v.Body = optimizeStatement(v.Body)
+		return v
+	} else if v, ok := s.(parser.Switch); ok {
+		v.Subject = optimizeExpression(v.Subject)
+		cases := make([]parser.SwitchCase, len(v.Cases))
+		for i, c := range v.Cases {
+			cases[i] = parser.SwitchCase{Patterns: optimizeExpressions(c.Patterns), Body: optimizeStatement(c.Body)}
+		}
+		v.Cases = cases
+		v.Default = optimizeStatement(v.Default)
+		return v
+	} else if v, ok := s.(parser.ReturnStmt); ok {
+		v.Value = optimizeExpression(v.Value)
+		return v
+	} else if v, ok := s.(parser.YieldStmt); ok {
+		v.Value = optimizeExpression(v.Value)
+		return v
+	}
+
+	return s
+}
+
+// An "if" decided by a literal becomes the branch it takes, or nothing at all
+func optimizeIf(v parser.If) parser.Stmt {
+	v.Condition = optimizeExpression(v.Condition)
+	v.ThenBranch = optimizeStatement(v.ThenBranch)
+	v.ElseBranch = optimizeStatement(v.ElseBranch)
+
+	if literal, ok := v.Condition.(parser.LiteralExpression); ok {
+		if isTruthy(literal.Value) {
+			return v.ThenBranch
+		}
+		return v.ElseBranch
+	}
+
+	return v
+}
+
+// A loop that never runs is dropped, and one that always runs loses its condition
+func optimizeWhile(v parser.While) parser.Stmt {
+	v.Condition = optimizeExpression(v.Condition)
+	v.Body = optimizeStatement(v.Body)
+	v.Increment = optimizeExpression(v.Increment)
+
+	if literal, ok := v.Condition.(parser.LiteralExpression); ok {
+		if !isTruthy(literal.Value) {
+			return nil
+		}
+		v.Condition = nil
+	}
+
+	return v
+}
+
+func optimizeExpressions(exprs []parser.Expression) []parser.Expression {
+	optimized := make([]parser.Expression, len(exprs))
+	for i, e := range exprs {
+		optimized[i] = optimizeExpression(e)
+	}
+	return optimized
+}
+
+func optimizeExpression(expr parser.Expression) parser.Expression {
+	if v, ok := expr.(parser.GroupingExpression); ok {
+		inner := optimizeExpression(v.Expression)
+		if _, isLiteral := inner.(parser.LiteralExpression); isLiteral {
+			return inner
+		}
+		return parser.GroupingExpression{Expression: inner}
+	} else if v, ok := expr.(parser.UnaryExpression); ok {
+		v.Right = optimizeExpression(v.Right)
+		return foldUnary(v)
+	} else if v, ok := expr.(parser.BinaryExpression); ok {
+		v.Left = optimizeExpression(v.Left)
+		v.Right = optimizeExpression(v.Right)
+		return foldBinary(v)
+	} else if v, ok := expr.(parser.LogicalExpression); ok {
+		v.Left = optimizeExpression(v.Left)
+		v.Right = optimizeExpression(v.Right)
+		return foldLogical(v)
+	} else if v, ok := expr.(parser.ConditionalExpression); ok {
+		v.Condition = optimizeExpression(v.Condition)
+		v.ThenBranch = optimizeExpression(v.ThenBranch)
+		v.ElseBranch = optimizeExpression(v.ElseBranch)
+		if literal, ok := v.Condition.(parser.LiteralExpression); ok {
+			if isTruthy(literal.Value) {
+				return v.ThenBranch
+			}
+			return v.ElseBranch
+		}
+		return v
+	} else if v, ok := expr.(parser.AssignmentExpression); ok {
+		v.Value = optimizeExpression(v.Value)
+		return v
+	} else if v, ok := expr.(parser.RangeExpression); ok {
+		v.Start = optimizeExpression(v.Start)
+		v.End = optimizeExpression(v.End)
+		v.Step = optimizeExpression(v.Step)
+		return v
+	} else if v, ok := expr.(parser.CallExpression); ok {
+		return optimizeCall(v)
+	} else if v, ok := expr.(parser.SpawnExpression); ok {
+		v.Call = optimizeCall(v.Call)
+		return v
+	}
+
+	return expr
+}
+
+func optimizeCall(v parser.CallExpression) parser.CallExpression {
+	v.Callee = optimizeExpression(v.Callee)
+	v.Arguments = optimizeExpressions(v.Arguments)
+	return v
+}
+
+func foldUnary(v parser.UnaryExpression) parser.Expression {
+	right, ok := v.Right.(parser.LiteralExpression)
+	if !ok {
+		return v
+	}
+
+	if v.Operator.TokenType == lexer.TokenNegation {
+		return parser.LiteralExpression{Value: !isTruthy(right.Value)}
+	}
+
+	if n, isNum := right.Value.(float64); isNum {
+		return parser.LiteralExpression{Value: -n}
+	}
+
+	return v
+}
+
+func foldBinary(v parser.BinaryExpression) parser.Expression {
+	left, isLeftLiteral := v.Left.(parser.LiteralExpression)
+	right, isRightLiteral := v.Right.(parser.LiteralExpression)
+	if !isLeftLiteral || !isRightLiteral {
+		return v
+	}
+
+	switch v.Operator.TokenType {
+	case lexer.TokenEqualEqual:
+		return parser.LiteralExpression{Value: left.Value == right.Value}
+	case lexer.TokenNotEqualTo:
+		return parser.LiteralExpression{Value: left.Value != right.Value}
+	case lexer.TokenPlus:
+		ls, isLString := left.Value.(string)
+		rs, isRString := right.Value.(string)
+		if isLString && isRString {
+			return parser.LiteralExpression{Value: ls + rs}
+		}
+	}
+
+	l, isLNum := left.Value.(float64)
+	r, isRNum := right.Value.(float64)
+	if !isLNum || !isRNum {
+		return v
+	}
+
+	switch v.Operator.TokenType {
+	case lexer.TokenPlus:
+		return parser.LiteralExpression{Value: l + r}
+	case lexer.TokenMinus:
+		return parser.LiteralExpression{Value: l - r}
+	case lexer.TokenMult:
+		return parser.LiteralExpression{Value: l * r}
+	case lexer.TokenDivision:
+		return parser.LiteralExpression{Value: l / r}
+	case lexer.TokenModulo:
+		if int(r) == 0 {
+			return v
+		}
+		return parser.LiteralExpression{Value: float64(int(l) % int(r))}
+	case lexer.TokenExponentation:
+		return parser.LiteralExpression{Value: math.Pow(l, r)}
+	case lexer.TokenGreaterThan:
+		return parser.LiteralExpression{Value: l > r}
+	case lexer.TokenGreaterEqual:
+		return parser.LiteralExpression{Value: l >= r}
+	case lexer.TokenLessThan:
+		return parser.LiteralExpression{Value: l < r}
+	case lexer.TokenLessEqual:
+		return parser.LiteralExpression{Value: l <= r}
+	}
+
+	return v
+}
+
+// A literal left side decides whether the right side runs at all
+func foldLogical(v parser.LogicalExpression) parser.Expression {
+	left, ok := v.Left.(parser.LiteralExpression)
+	if !ok {
+		return v
+	}
+
+	switch v.Operator.TokenType {
+	case lexer.TokenNullCoalescing:
+		if left.Value != nil {
+			return left
+		}
+	case lexer.TokenOr:
+		if isTruthy(left.Value) {
+			return left
+		}
+	default:
+		if !isTruthy(left.Value) {
+			return left
+		}
+	}
+
+	return v.Right
+}
+
+func isTruthy(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+
+	if b, ok := v.(bool); ok {
+		return b
+	}
+
+	return true
+}
@@ -0,0 +1,113 @@
+package optimizer
+
+import (
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"testing"
+)
+
+// optimizeLast optimizes a program, giving what became of its last statement
+func optimizeLast(t *testing.T, source string) parser.Stmt {
+	t.Helper()
+
+	stmts := Optimize(parser.Parse(lexer.GetTokens(source)))
+	if len(stmts) == 0 {
+		t.Fatalf("%q no tiene sentencias", source)
+	}
+	return stmts[len(stmts)-1]
+}
+
+// statements leaves out the empty placeholder the parser puts at the start of bodies
+func statements(stmts []parser.Stmt) []parser.Stmt {
+	var result []parser.Stmt
+	for _, s := range stmts {
+		if s != nil {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func TestFolding(t *testing.T) {
+	cases := []struct {
+		source string
+		value  interface{}
+	}{
+		{"servir 1 + 2 * 3;", 7.0},
+		{"servir (2 ^ 10) - -(4) / 2;", 1026.0},
+		{`servir "co" + "cido";`, "cocido"},
+		{"servir !falso != verdadero;", false},
+		{"servir falso ? 1 : 2;", 2.0},
+		{`servir nulo ?? "x";`, "x"},
+		{"servir falso y 1 / 0;", false},
+	}
+
+	for _, c := range cases {
+		print, ok := optimizeLast(t, c.source).(parser.Print)
+		if !ok {
+			t.Errorf("%q dejó de ser un servir", c.source)
+			continue
+		}
+		if literal, ok := print.Expr.(parser.LiteralExpression); !ok || literal.Value != c.value {
+			t.Errorf("%q: se esperaba el literal %v, se obtuvo %#v", c.source, c.value, print.Expr)
+		}
+	}
+}
+
+func TestFailingOperationsAreKept(t *testing.T) {
+	for _, source := range []string{"servir 1 % 0;", `servir "a" - 1;`, "servir -nulo;"} {
+		print := optimizeLast(t, source).(parser.Print)
+		if _, folded := print.Expr.(parser.LiteralExpression); folded {
+			t.Errorf("%q se plegó, pero debería fallar al ejecutarse", source)
+		}
+	}
+}
+
+func TestDecidedBranches(t *testing.T) {
+	if _, ok := optimizeLast(t, "si (verdadero) servir 1; nope servir 2;").(parser.Print); !ok {
+		t.Error("un si con la condición verdadero no se reemplazó por su rama")
+	}
+	if s := optimizeLast(t, "si (falso) servir 1;"); s != nil {
+		t.Errorf("un si con la condición falso sin nope quedó como %#v", s)
+	}
+	if s := optimizeLast(t, "mientras (falso) servir 1;"); s != nil {
+		t.Errorf("un mientras que nunca corre quedó como %#v", s)
+	}
+}
+
+func TestLoopsWithoutCondition(t *testing.T) {
+	for _, source := range []string{"por (;;) { romper; }", "mientras (verdadero) romper;"} {
+		loop, ok := optimizeLast(t, source).(parser.While)
+		if !ok {
+			t.Errorf("%q dejó de ser un ciclo", source)
+			continue
+		}
+		if loop.Condition != nil {
+			t.Errorf("%q conserva la condición %#v", source, loop.Condition)
+		}
+	}
+}
+
+func TestUnreachableStatements(t *testing.T) {
+	cases := []struct {
+		source string
+		kept   int
+	}{
+		{"fn f() { servir 1; sazonar 2; servir 3; }", 2},
+		{"fn f() { por cada i en 0..3 { romper; servir i; } }", 1},
+		{"fn f() { por cada i en 0..3 { continuar; servir i; } servir 4; }", 2},
+	}
+
+	for _, c := range cases {
+		body := statements(optimizeLast(t, c.source).(parser.FnDecl).Body)
+		if len(body) != c.kept {
+			t.Errorf("%q: se esperaban %d sentencias en el cuerpo, quedaron %d", c.source, c.kept, len(body))
+		}
+
+		if loop, ok := body[0].(parser.ForEach); ok {
+			if kept := statements(loop.Body.(parser.Block).Statements); len(kept) != 1 {
+				t.Errorf("%q: el cuerpo del ciclo conserva %d sentencias", c.source, len(kept))
+			}
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package parser
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/lexer"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+/*
+Dumps of the AST, for people debugging the grammar and for tools.
+
+Dump writes an indented S-expression. EncodeJSON writes every node as an object with
+its "type", one key per field and its "span"; DecodeJSON reads that back. Nodes are
+named after their Go types and fields after their Go fields, starting in lowercase,
+never by the numeric Type constants. Tokens are written as their lexeme and position,
+and lexed again when decoded.
+
+Neither kind of dump shows the empty placeholders the parser starts some lists with, nor
+the depths and slots the resolver fills in; DecodeJSON puts the placeholders back and
+leaves the variables global, as the parser does.
+*/
+
+// The names nodes go by in dumps
+var nodeTypes = map[string]reflect.Type{
+	"Statement":             reflect.TypeOf(Statement{}),
+	"Print":                 reflect.TypeOf(Print{}),
+	"Declaration":           reflect.TypeOf(Declaration{}),
+	"Block":                 reflect.TypeOf(Block{}),
+	"If":                    reflect.TypeOf(If{}),
+	"While":                 reflect.TypeOf(While{}),
+	"ForEach":               reflect.TypeOf(ForEach{}),
+	"FnDecl":                reflect.TypeOf(FnDecl{}),
+	"Switch":                reflect.TypeOf(Switch{}),
+	"ReturnStmt":            reflect.TypeOf(ReturnStmt{}),
+	"YieldStmt":             reflect.TypeOf(YieldStmt{}),
+	"BreakStmt":             reflect.TypeOf(BreakStmt{}),
+	"ContinueStmt":          reflect.TypeOf(ContinueStmt{}),
+	"BinaryExpression":      reflect.TypeOf(BinaryExpression{}),
+	"LiteralExpression":     reflect.TypeOf(LiteralExpression{}),
+	"GroupingExpression":    reflect.TypeOf(GroupingExpression{}),
+	"UnaryExpression":       reflect.TypeOf(UnaryExpression{}),
+	"VariableExpression":    reflect.TypeOf(VariableExpression{}),
+	"AssignmentExpression":  reflect.TypeOf(AssignmentExpression{}),
+	"LogicalExpression":     reflect.TypeOf(LogicalExpression{}),
+	"ConditionalExpression": reflect.TypeOf(ConditionalExpression{}),
+	"RangeExpression":       reflect.TypeOf(RangeExpression{}),
+	"CallExpression":        reflect.TypeOf(CallExpression{}),
+	"SpawnExpression":       reflect.TypeOf(SpawnExpression{}),
+}
+
+var nodeNames = make(map[reflect.Type]string)
+
+func init() {
+	for name, t := range nodeTypes {
+		nodeNames[t] = name
+	}
+}
+
+var tokenType = reflect.TypeOf(lexer.Token{})
+
+// The lists that start with an empty placeholder, along with the program itself
+var placeholderFields = map[string]bool{
+	"Block.Statements":         true,
+	"FnDecl.Parameters":        true,
+	"FnDecl.Body":              true,
+	"CallExpression.Arguments": true,
+}
+
+// The fields the resolver fills in, which only mean something for the tree it resolved
+var resolverFields = map[string]bool{"Depth": true, "Slot": true}
+
+func hasPlaceholder(node reflect.Type, field reflect.StructField) bool {
+	return placeholderFields[node.Name()+"."+field.Name]
+}
+
+// withoutPlaceholder skips the empty element a list starts with
+func withoutPlaceholder(v reflect.Value) reflect.Value {
+	if v.Len() == 0 {
+		return v
+	}
+	return v.Slice(1, v.Len())
+}
+
+// A Position is a place in the source, both counting from 1
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// A Span goes from where the first token a node keeps starts to where the last one ends.
+// Nodes don't keep every token they were written with, such as keywords and semicolons,
+// so spans may fall short of the whole code of a statement
+type Span struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// SpanOf gives the span of a node, false when it keeps no tokens
+func SpanOf(node interface{}) (Span, bool) {
+	var span Span
+	found := false
+
+	visitTokens(reflect.ValueOf(node), func(t lexer.Token) {
+		start, end := tokenStart(t), tokenEnd(t)
+		if !found || before(start, span.Start) {
+			span.Start = start
+		}
+		if !found || before(span.End, end) {
+			span.End = end
+		}
+		found = true
+	})
+
+	return span, found
+}
+
+func tokenStart(t lexer.Token) Position {
+	return Position{t.Line, t.Column}
+}
+
+// tokenEnd is just past the last character of a token, which may span lines if it is a string
+func tokenEnd(t lexer.Token) Position {
+	lines := strings.Split(t.Lexeme, "\n")
+	if len(lines) == 1 {
+		return Position{t.Line, t.Column + len([]rune(t.Lexeme))}
+	}
+	return Position{t.Line + len(lines) - 1, len([]rune(lines[len(lines)-1])) + 1}
+}
+
+func before(a Position, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// visitTokens calls visit for every token with a position found in v, at any depth
+func visitTokens(v reflect.Value, visit func(lexer.Token)) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			visitTokens(v.Elem(), visit)
+		}
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			if t := v.Interface().(lexer.Token); t.Line > 0 {
+				visit(t)
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			visitTokens(v.Field(i), visit)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			visitTokens(v.Index(i), visit)
+		}
+	}
+}
+
+func fieldKey(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// Dump describes an AST as an indented S-expression, one node per line. Tokens are
+// shown by their lexeme, and positions, resolver slots and tokens that only repeat
+// what the node is, such as keywords, are left out
+func Dump(stmts []Stmt) string {
+	var lines []string
+	for _, s := range stmts {
+		if s != nil {
+			lines = append(lines, dumpNode(reflect.ValueOf(s), 0)...)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Tokens left out of the S-expressions
+var hiddenTokens = map[string]bool{"Keyword": true, "ClosingParenteses": true, "Question": true, "Token": true}
+
+func dumpNode(v reflect.Value, depth int) []string {
+	name, isNode := nodeNames[v.Type()]
+	if !isNode {
+		name = v.Type().Name()
+	}
+
+	header := []string{name}
+	var children []string
+
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+
+		switch {
+		case field.Type == tokenType:
+			if t := value.Interface().(lexer.Token); !hiddenTokens[field.Name] && t.Lexeme != "" {
+				header = append(header, t.Lexeme)
+			}
+		case field.Type == reflect.SliceOf(tokenType):
+			var lexemes []string
+			for _, t := range value.Interface().([]lexer.Token) {
+				if t.Lexeme != "" {
+					lexemes = append(lexemes, t.Lexeme)
+				}
+			}
+			header = append(header, "("+strings.Join(lexemes, " ")+")")
+		case field.Name == "Value" && field.Type.Kind() == reflect.Interface && v.Type() == nodeTypes["LiteralExpression"]:
+			header = append(header, dumpLiteral(value.Interface()))
+		case field.Type.Kind() == reflect.Bool:
+			if value.Bool() {
+				header = append(header, ":"+fieldKey(field.Name))
+			}
+		case field.Type.Kind() == reflect.Interface, field.Type.Kind() == reflect.Struct:
+			children = append(children, dumpChild(value, depth+1)...)
+		case field.Type.Kind() == reflect.Slice:
+			if hasPlaceholder(v.Type(), field) {
+				value = withoutPlaceholder(value)
+			}
+			for j := 0; j < value.Len(); j++ {
+				children = append(children, dumpChild(value.Index(j), depth+1)...)
+			}
+		}
+	}
+
+	lines := append([]string{strings.Repeat("  ", depth) + "(" + strings.Join(header, " ")}, children...)
+	lines[len(lines)-1] += ")"
+	return lines
+}
+
+func dumpChild(v reflect.Value, depth int) []string {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	return dumpNode(v, depth)
+}
+
+func dumpLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nulo"
+	case bool:
+		if v {
+			return "verdadero"
+		}
+		return "falso"
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// EncodeJSON describes an AST as indented JSON
+func EncodeJSON(stmts []Stmt) ([]byte, error) {
+	return json.MarshalIndent(encodeValue(withoutPlaceholder(reflect.ValueOf(stmts))), "", "  ")
+}
+
+func encodeValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = encodeValue(v.Index(i))
+		}
+		return values
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			return encodeToken(v.Interface().(lexer.Token))
+		}
+
+		object := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field, value := v.Type().Field(i), v.Field(i)
+
+			switch {
+			case resolverFields[field.Name]:
+				continue
+			case hasPlaceholder(v.Type(), field):
+				value = withoutPlaceholder(value)
+			}
+			object[fieldKey(field.Name)] = encodeValue(value)
+		}
+
+		if name, isNode := nodeNames[v.Type()]; isNode {
+			object["type"] = name
+			if span, ok := SpanOf(v.Interface()); ok {
+				object["span"] = span
+			}
+		}
+		return object
+	}
+
+	return v.Interface()
+}
+
+// Tokens that were never written, such as those of the nodes "por" loops are desugared into, are null
+func encodeToken(t lexer.Token) interface{} {
+	if t == (lexer.Token{}) {
+		return nil
+	}
+	return map[string]interface{}{"lexeme": t.Lexeme, "line": t.Line, "column": t.Column}
+}
+
+// DecodeJSON reads back an AST written by EncodeJSON
+func DecodeJSON(data []byte) ([]Stmt, error) {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	var stmts []Stmt
+	err := decodeList(reflect.ValueOf(&stmts).Elem(), raw, true)
+	return stmts, err
+}
+
+// decodeList decodes a list, starting it with an empty placeholder if it should have one
+func decodeList(target reflect.Value, raw interface{}, placeholder bool) error {
+	if raw == nil {
+		return nil
+	}
+
+	values, ok := raw.([]interface{})
+	if !ok {
+		return fmt.Errorf("se esperaba una lista, se obtuvo %v", raw)
+	}
+
+	offset := 0
+	if placeholder {
+		offset = 1
+	}
+
+	slice := reflect.MakeSlice(target.Type(), len(values)+offset, len(values)+offset)
+	for i, value := range values {
+		if err := decodeValue(slice.Index(i+offset), value); err != nil {
+			return err
+		}
+	}
+	target.Set(slice)
+	return nil
+}
+
+func decodeValue(target reflect.Value, raw interface{}) error {
+	if raw == nil {
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.Interface:
+		// The literal value of a LiteralExpression
+		if target.NumMethod() == 0 {
+			target.Set(reflect.ValueOf(raw))
+			return nil
+		}
+
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("se esperaba un nodo, se obtuvo %v", raw)
+		}
+
+		name, _ := object["type"].(string)
+		t, ok := nodeTypes[name]
+		if !ok || !t.Implements(target.Type()) {
+			return fmt.Errorf("tipo de nodo inesperado: '%v'", name)
+		}
+
+		node := reflect.New(t).Elem()
+		if err := decodeValue(node, raw); err != nil {
+			return err
+		}
+		target.Set(node)
+	case reflect.Slice:
+		return decodeList(target, raw, false)
+	case reflect.Struct:
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("se esperaba un objeto, se obtuvo %v", raw)
+		}
+
+		if target.Type() == tokenType {
+			t, err := decodeToken(object)
+			target.Set(reflect.ValueOf(t))
+			return err
+		}
+
+		for i := 0; i < target.NumField(); i++ {
+			field := target.Type().Field(i)
+			raw := object[fieldKey(field.Name)]
+
+			var err error
+			switch {
+			case resolverFields[field.Name]:
+				// As the parser leaves them, for the resolver to fill in
+				if field.Name == "Depth" {
+					target.Field(i).SetInt(GlobalDepth)
+				}
+			case hasPlaceholder(target.Type(), field):
+				err = decodeList(target.Field(i), raw, true)
+			default:
+				err = decodeValue(target.Field(i), raw)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Int:
+		number, ok := raw.(float64)
+		if !ok {
+			return fmt.Errorf("se esperaba un número, se obtuvo %v", raw)
+		}
+		target.SetInt(int64(number))
+	case reflect.Bool:
+		value, ok := raw.(bool)
+		if !ok {
+			return fmt.Errorf("se esperaba un booleano, se obtuvo %v", raw)
+		}
+		target.SetBool(value)
+	}
+
+	return nil
+}
+
+// decodeToken lexes the lexeme of a token again, to get back its type and literal
+func decodeToken(object map[string]interface{}) (lexer.Token, error) {
+	lexeme, _ := object["lexeme"].(string)
+	line, _ := object["line"].(float64)
+	column, _ := object["column"].(float64)
+
+	var tokens []lexer.Token
+	diagnostics := errorHandler.Collect(func() {
+		tokens = lexer.GetTokens(lexeme)
+	})
+
+	if len(diagnostics) > 0 || len(tokens) != 2 {
+		return lexer.Token{}, fmt.Errorf("lexema inválido: '%v'", lexeme)
+	}
+
+	t := tokens[0]
+	t.Line, t.Column = int(line), int(column)
+	return t, nil
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"clase-mates-computacionales/cazuela/lexer"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A program with every kind of node, including a desugared "por" loop
+const program = `var x = 1;
+fn suma(a, b) {
+    sazonar a + b;
+}
+fn contar(n) {
+    por cada i en 0..n paso 2 {
+        producir i;
+    }
+}
+por (var i = 0; i < 3; i = i + 1) {
+    si (i == 1 y !falso) { continuar; } nope { servir i; }
+}
+mientras (x < 10) {
+    x = suma(x, 2);
+    si (x > 5 o nulo) romper;
+}
+segun (x) {
+    caso 1, 2: servir "poco";
+    caso 3..20: servir x > 4 ? "mucho" : -x;
+    otro: servir (x);
+}
+var t = lanzar suma(1, 2);
+servir contar(4);
+`
+
+func TestJSONRoundTrip(t *testing.T) {
+	stmts := Parse(lexer.GetTokens(program))
+
+	data, err := EncodeJSON(stmts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{`"depth"`, `"slot"`} {
+		if strings.Contains(string(data), key) {
+			t.Errorf("el JSON incluye %v, que solo tiene sentido para el resolver", key)
+		}
+	}
+
+	var raw []interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != len(stmts)-1 {
+		t.Errorf("el JSON tiene %d sentencias, el programa %d", len(raw), len(stmts)-1)
+	}
+	if path, ok := findNull(raw, "programa"); ok {
+		t.Errorf("el JSON incluye un marcador vacío en %v", path)
+	}
+
+	decoded, err := DecodeJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, stmts) {
+		t.Errorf("DecodeJSON no devolvió el árbol original\nesperado: %#v\nobtenido: %#v", stmts, decoded)
+	}
+}
+
+// findNull looks for a null element in the lists of a JSON value, giving where it is
+func findNull(value interface{}, path string) (string, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, element := range v {
+			if element == nil {
+				return fmt.Sprintf("%v[%d]", path, i), true
+			}
+			if found, ok := findNull(element, fmt.Sprintf("%v[%d]", path, i)); ok {
+				return found, true
+			}
+		}
+	case map[string]interface{}:
+		for key, element := range v {
+			if found, ok := findNull(element, path+"."+key); ok {
+				return found, true
+			}
+		}
+	}
+	return "", false
+}
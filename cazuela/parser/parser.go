@@ -27,13 +27,27 @@ stmt   		   → statement
 				| while
 				| if
 				| forLoop
+				| forEach
+				| switch
 				| ReturnStmt
+				| YieldStmt
+				| BreakStmt
+				| ContinueStmt
 
 forLoop		  → "por" "(" ( varDecl | exprStmt | ";" )
 				expression? ";"
 				expression? ")" statement ;
 
+forEach		  → "por" "cada" IDENTIFIER "en" expression statement ;
+
+switch		  → "segun" "(" expression ")" "{" case* default? "}" ;
+case		  → "caso" expression ( "," expression )* ":" statement ;
+default		  → "otro" ":" statement ;
+
 ReturnStmt 	  → "sazonar" expression? ";" ;
+YieldStmt 	  → "producir" expression? ";" ;
+BreakStmt 	  → "romper" ";" ;
+ContinueStmt  → "continuar" ";" ;
 
 if			   → "si" "(" expression ")" statement ( "nope" statement )? ;
 block     	   → "{" declaration* "}" ;
@@ -42,16 +56,21 @@ while 	       → "mientras" "(" expression ")" statement ;
 expression     → assignment ;
 
 assigment      → identifier "=" assignment\
-				| logic_or ;
+				| conditional ;
 
+conditional    → coalescing ( "?" expression ":" conditional )? ;
+coalescing     → logic_or ( "??" logic_or )* ;
 logic_or  	   → logic_and ( "o" logic_and )* ;
 logic_and  	   → equality ( "y" equality )* ;
 equality       → comparison ( ( "!=" | "==" ) comparison )* ;
-comparison     → addition ( ( ">" | ">=" | "<" | "<=" ) addition )* ;
+comparison     → range ( ( ">" | ">=" | "<" | "<=" ) range )* ;
+range		   → addition ( ".." addition ( "paso" addition )? )? ;
 addition       → multiplication ( ( "-" | "+" ) multiplication )* ;
 multiplication → exponentiation ( ( "/" | "*" | "%" ) exponentiation )* ;
 exponentiation → unary ( ( "^" ) unary )* ;
 unary          → ( "!" | "-" ) unary ;
+				 | spawn ;
+spawn		   → "lanzar" call
 				 | call ;
 call		   → primary ( "(" arguments? ")" )* ;
 arguments 	   → expression ( "," expression )* ;
@@ -63,23 +82,31 @@ primary        → "verdadero" | "falso" | "nulo"
 */
 
 const (
-	TypeBinary     = 0x10
-	TypeLiteral    = 0x11
-	TypeGrouping   = 0x12
-	TypeUnary      = 0x13
-	TypeVariable   = 0x14
-	TypeAssignment = 0x15
-	TypeLogical    = 0x16
-	TypeWhile      = 0x17
-	TypeCall       = 0x18
-	TypeFn         = 0x19
-	TypeReturn     = 0x1A
+	TypeBinary      = 0x10
+	TypeLiteral     = 0x11
+	TypeGrouping    = 0x12
+	TypeUnary       = 0x13
+	TypeVariable    = 0x14
+	TypeAssignment  = 0x15
+	TypeLogical     = 0x16
+	TypeWhile       = 0x17
+	TypeCall        = 0x18
+	TypeFn          = 0x19
+	TypeReturn      = 0x1A
+	TypeConditional = 0x1B
+	TypeRange       = 0x1C
+	TypeSpawn       = 0x1D
 
 	TypeStatement   = 0x20
 	TypePrint       = 0x21
 	TypeDeclaration = 0x22
 	TypeBlock       = 0x23
 	TypeIf          = 0x24
+	TypeSwitch      = 0x25
+	TypeForEach     = 0x26
+	TypeYield       = 0x27
+	TypeBreak       = 0x28
+	TypeContinue    = 0x29
 )
 
 type Stmt interface {
@@ -88,10 +115,12 @@ type Stmt interface {
 
 type Statement struct {
 	Expr Expression
+	Line int
 }
 
 type Print struct {
 	Expr Expression
+	Line int
 }
 
 type Declaration struct {
@@ -107,17 +136,46 @@ type If struct {
 	Condition  Expression
 	ThenBranch Stmt
 	ElseBranch Stmt
+	Line       int
 }
 
+// A While repeats its body while Condition holds. Increment is only set by
+// desugared "por" loops, and runs after every iteration, even when it continues
 type While struct {
 	Condition Expression
 	Body      Stmt
+	Increment Expression
+	Line      int
 }
 
+// A ForEach runs its body once for every value produced by Iterable, binding it to Variable
+type ForEach struct {
+	Keyword  lexer.Token
+	Variable lexer.Token
+	Iterable Expression
+	Body     Stmt
+}
+
+// A FnDecl declares a function. IsGenerator is set when its body contains a "producir"
 type FnDecl struct {
-	Name       lexer.Token
-	Parameters []lexer.Token
-	Body       []Stmt
+	Name        lexer.Token
+	Parameters  []lexer.Token
+	Body        []Stmt
+	IsGenerator bool
+}
+
+// A Switch compares its subject against the patterns of each case, running the first match
+type Switch struct {
+	Keyword lexer.Token
+	Subject Expression
+	Cases   []SwitchCase
+	Default Stmt
+}
+
+// A SwitchCase holds the patterns that select its body inside a Switch
+type SwitchCase struct {
+	Patterns []Expression
+	Body     Stmt
 }
 
 type ReturnStmt struct {
@@ -125,6 +183,22 @@ type ReturnStmt struct {
 	Value   Expression
 }
 
+// A YieldStmt hands a value to whoever is consuming a generator, pausing it until the next one is asked for
+type YieldStmt struct {
+	Keyword lexer.Token
+	Value   Expression
+}
+
+// A BreakStmt leaves the innermost loop
+type BreakStmt struct {
+	Keyword lexer.Token
+}
+
+// A ContinueStmt skips to the next iteration of the innermost loop
+type ContinueStmt struct {
+	Keyword lexer.Token
+}
+
 // Expression is the base interface for all expressions
 type Expression interface {
 	GetType() int
@@ -137,9 +211,10 @@ type BinaryExpression struct {
 	Right    Expression
 }
 
-// A LiteralExpression holds a simple literal
+// A LiteralExpression holds a simple literal. Token is the one it was written as, if any
 type LiteralExpression struct {
 	Value interface{}
+	Token lexer.Token
 }
 
 // A GroupingExpression holds more expressions inside it :D
@@ -153,13 +228,25 @@ type UnaryExpression struct {
 	Right    Expression
 }
 
+// Variables are global until the resolver finds them in a local scope, Depth scopes out
+// from where they are used, at the given Slot
+const GlobalDepth = -1
+
+// A Slot of UnknownSlot is that of a function declared further down its block, which is
+// looked up by name in the scope Depth levels out
+const UnknownSlot = -1
+
 type VariableExpression struct {
-	Name lexer.Token
+	Name  lexer.Token
+	Depth int
+	Slot  int
 }
 
 type AssignmentExpression struct {
 	Name  lexer.Token
 	Value Expression
+	Depth int
+	Slot  int
 }
 
 type LogicalExpression struct {
@@ -168,12 +255,35 @@ type LogicalExpression struct {
 	Right    Expression
 }
 
+// A ConditionalExpression picks one of two expressions depending on its condition
+type ConditionalExpression struct {
+	Condition  Expression
+	Question   lexer.Token
+	ThenBranch Expression
+	ElseBranch Expression
+}
+
+// A RangeExpression describes the numbers from Start up to, but not including, End.
+// Step is nil when the range counts one by one
+type RangeExpression struct {
+	Start    Expression
+	Operator lexer.Token
+	End      Expression
+	Step     Expression
+}
+
 type CallExpression struct {
 	Callee            Expression
 	ClosingParenteses lexer.Token
 	Arguments         []Expression
 }
 
+// A SpawnExpression runs a call as a concurrent task
+type SpawnExpression struct {
+	Keyword lexer.Token
+	Call    CallExpression
+}
+
 func (st Statement) GetStmtType() int {
 	return TypeStatement
 }
@@ -198,14 +308,65 @@ func (st While) GetStmtType() int {
 	return TypeWhile
 }
 
+func (st ForEach) GetStmtType() int {
+	return TypeForEach
+}
+
 func (st FnDecl) GetStmtType() int {
 	return TypeFn
 }
 
+func (st Switch) GetStmtType() int {
+	return TypeSwitch
+}
+
 func (st ReturnStmt) GetStmtType() int {
 	return TypeReturn
 }
 
+func (st YieldStmt) GetStmtType() int {
+	return TypeYield
+}
+
+func (st BreakStmt) GetStmtType() int {
+	return TypeBreak
+}
+
+func (st ContinueStmt) GetStmtType() int {
+	return TypeContinue
+}
+
+// StatementLine gives the line a statement starts at, or 0 for blocks, which only group others
+func StatementLine(s Stmt) int {
+	switch v := s.(type) {
+	case Statement:
+		return v.Line
+	case Print:
+		return v.Line
+	case Declaration:
+		return v.Name.Line
+	case If:
+		return v.Line
+	case While:
+		return v.Line
+	case ForEach:
+		return v.Keyword.Line
+	case FnDecl:
+		return v.Name.Line
+	case Switch:
+		return v.Keyword.Line
+	case ReturnStmt:
+		return v.Keyword.Line
+	case YieldStmt:
+		return v.Keyword.Line
+	case BreakStmt:
+		return v.Keyword.Line
+	case ContinueStmt:
+		return v.Keyword.Line
+	}
+	return 0
+}
+
 func (be BinaryExpression) GetType() int {
 	return TypeBinary
 }
@@ -234,22 +395,47 @@ func (lo LogicalExpression) GetType() int {
 	return TypeLogical
 }
 
+func (co ConditionalExpression) GetType() int {
+	return TypeConditional
+}
+
+func (ra RangeExpression) GetType() int {
+	return TypeRange
+}
+
 func (ca CallExpression) GetType() int {
 	return TypeCall
 }
 
+func (sp SpawnExpression) GetType() int {
+	return TypeSpawn
+}
+
 var current int
 var tokens []lexer.Token
 
+// Function and loop bookkeeping, used to validate statements and flag generators
+var functionDepth int
+var loopDepth int
+var hasYielded bool
+
 // Parse takes a series of tokens and returns an AST
 func Parse(t []lexer.Token) []Stmt {
 	current = 0
 	tokens = t
+	functionDepth = 0
+	loopDepth = 0
+	hasYielded = false
 
 	statements := make([]Stmt, 1)
 
 	for !isAtEnd() {
 		statements = append(statements, declaration())
+
+		// When errors don't end the program, there is no telling where the next statement starts
+		if errorHandler.HasFatalled {
+			break
+		}
 	}
 
 	return statements
@@ -288,9 +474,19 @@ func function() FnDecl {
 
 	consume(lexer.TokenLeftBrace, "Se esperaba un { al empezar la función.")
 
+	enclosingHasYielded, enclosingLoopDepth := hasYielded, loopDepth
+	hasYielded = false
+	loopDepth = 0
+	functionDepth++
+
+	defer func() {
+		functionDepth--
+		hasYielded, loopDepth = enclosingHasYielded, enclosingLoopDepth
+	}()
+
 	body := block()
 
-	return FnDecl{name, parameters, body}
+	return FnDecl{name, parameters, body, hasYielded}
 }
 
 func varDeclaration() Stmt {
@@ -329,10 +525,22 @@ func statement() Stmt {
 		return forStatement()
 	}
 
+	if match(lexer.TokenSwitch) {
+		return switchStatement()
+	}
+
 	if match(lexer.TokenReturn) {
 		return returnStatement()
 	}
 
+	if match(lexer.TokenYield) {
+		return yieldStatement()
+	}
+
+	if match(lexer.TokenBreak, lexer.TokenContinue) {
+		return loopControlStatement()
+	}
+
 	return expressionStatement()
 }
 
@@ -350,6 +558,10 @@ func block() []Stmt {
 func returnStatement() Stmt {
 	keyword := previous()
 
+	if functionDepth == 0 {
+		errorAt(keyword, "Solo se puede sazonar dentro de una función.", "[Cocinado]")
+	}
+
 	var value Expression
 
 	if !check(lexer.TokenSemiColon) {
@@ -361,16 +573,67 @@ func returnStatement() Stmt {
 	return ReturnStmt{keyword, value}
 }
 
+func yieldStatement() Stmt {
+	keyword := previous()
+
+	if functionDepth == 0 {
+		errorAt(keyword, "Solo se puede producir dentro de una función.", "[Cocinado]")
+	}
+	hasYielded = true
+
+	var value Expression
+
+	if !check(lexer.TokenSemiColon) {
+		value = expression()
+	}
+
+	consume(lexer.TokenSemiColon, "Se esperaba un ; al final del producir")
+
+	return YieldStmt{keyword, value}
+}
+
+func loopControlStatement() Stmt {
+	keyword := previous()
+
+	if loopDepth == 0 {
+		errorAt(keyword, fmt.Sprintf("'%v' solo puede usarse dentro de un ciclo.", keyword.Lexeme), "[Cocinado]")
+	}
+
+	consume(lexer.TokenSemiColon, fmt.Sprintf("Se esperaba un ; después de '%v'.", keyword.Lexeme))
+
+	if keyword.TokenType == lexer.TokenBreak {
+		return BreakStmt{keyword}
+	}
+	return ContinueStmt{keyword}
+}
+
+// loopBody parses the body of any loop, allowing "romper" and "continuar" inside it
+func loopBody() Stmt {
+	loopDepth++
+	defer func() {
+		loopDepth--
+	}()
+
+	return statement()
+}
+
 func printStatement() Stmt {
+	line := previous().Line
 	value := expression()
 
 	consume(lexer.TokenSemiColon, "Se buscaba un ; al final.")
 
-	return Print{value}
+	return Print{value, line}
 }
 
 // Caramelizer for whiles
 func forStatement() Stmt {
+	if match(lexer.TokenEach) {
+		return forEachStatement()
+	}
+
+	line := previous().Line
+
 	consume(lexer.TokenLeftParentheses, "Se esperaba un ( después de 'por'.")
 	var initializer Stmt
 	if match(lexer.TokenLet) {
@@ -392,34 +655,88 @@ func forStatement() Stmt {
 
 	consume(lexer.TokenRightParenteses, "Se esperaba un ) después del 'por'.")
 
-	body := statement()
-
-	if increment != nil {
-		body = Block{[]Stmt{body, Statement{increment}}}
-	}
+	body := loopBody()
 
 	if condition == nil {
-		condition = LiteralExpression{true}
+		condition = LiteralExpression{Value: true}
 	}
 
-	body = While{condition, body}
+	body = While{condition, body, increment, line}
 
 	if initializer != nil {
-		body = Block{[]Stmt{initializer, body}}
+		body = Block{[]Stmt{nil, initializer, body}}
 	}
 
 	return body
 }
 
+func switchStatement() Stmt {
+	keyword := previous()
+	consume(lexer.TokenLeftParentheses, "Se esperaba un ( después de 'segun'.")
+	subject := expression()
+	consume(lexer.TokenRightParenteses, "Se esperaba un ) después del sujeto de 'segun'.")
+	consume(lexer.TokenLeftBrace, "Se esperaba un { al empezar el 'segun'.")
+
+	var cases []SwitchCase
+	var defaultBranch Stmt
+	seen := make(map[interface{}]bool)
+
+	for !check(lexer.TokenRightBrace) && !isAtEnd() {
+		if match(lexer.TokenDefault) {
+			if defaultBranch != nil {
+				errorAt(previous(), "Solo puede haber un 'otro' por 'segun'.", "[Cocinado]")
+			}
+			consume(lexer.TokenColon, "Se esperaba un : después de 'otro'.")
+			defaultBranch = statement()
+			continue
+		}
+
+		caseKeyword := consume(lexer.TokenCase, "Se esperaba 'caso' u 'otro' dentro del 'segun'.")
+		patterns := []Expression{expression()}
+		for match(lexer.TokenComma) {
+			patterns = append(patterns, expression())
+		}
+		consume(lexer.TokenColon, "Se esperaba un : después de los patrones del caso.")
+
+		for _, pattern := range patterns {
+			if literal, ok := pattern.(LiteralExpression); ok {
+				if seen[literal.Value] {
+					errorHandler.RaiseWarning(fmt.Sprintf("El caso %v está repetido y nunca se ejecutará", literal.Value), caseKeyword.Line, "[Cocinado]")
+				}
+				seen[literal.Value] = true
+			}
+		}
+
+		cases = append(cases, SwitchCase{patterns, statement()})
+	}
+
+	consume(lexer.TokenRightBrace, "Se esperaba un } al final del 'segun'.")
+
+	return Switch{keyword, subject, cases, defaultBranch}
+}
+
+func forEachStatement() Stmt {
+	keyword := previous()
+	variable := consume(lexer.TokenIdentifier, "Se esperaba un nombre de variable después de 'por cada'.")
+	consume(lexer.TokenIn, "Se esperaba 'en' después de la variable del 'por cada'.")
+	iterable := expression()
+
+	body := loopBody()
+
+	return ForEach{keyword, variable, iterable, body}
+}
+
 func expressionStatement() Stmt {
+	line := peek().Line
 	expr := expression()
 
 	consume(lexer.TokenSemiColon, "Se buscaba un ; al final.")
 
-	return Statement{expr}
+	return Statement{expr, line}
 }
 
 func ifStatement() Stmt {
+	line := previous().Line
 	consume(lexer.TokenLeftParentheses, "Se esperaba un ( en la condición.")
 	condition := expression()
 	consume(lexer.TokenRightParenteses, "Se esperaba un ) al final de la condición.")
@@ -431,17 +748,18 @@ func ifStatement() Stmt {
 		elseBranch = statement()
 	}
 
-	return If{condition, thenBranch, elseBranch}
+	return If{condition, thenBranch, elseBranch, line}
 }
 
 func whileStatement() Stmt {
+	line := previous().Line
 	consume(lexer.TokenLeftParentheses, "Se esperaba un ( en la condición.")
 	condition := expression()
 	consume(lexer.TokenRightParenteses, "Se esperaba un ) al final de la condición.")
 
-	body := statement()
+	body := loopBody()
 
-	return While{condition, body}
+	return While{condition, body, nil, line}
 }
 
 func expression() Expression {
@@ -449,7 +767,7 @@ func expression() Expression {
 }
 
 func assignment() Expression {
-	expr := or()
+	expr := conditional()
 
 	if match(lexer.TokenEqual) {
 		equals := previous()
@@ -457,10 +775,37 @@ func assignment() Expression {
 
 		if v, ok := expr.(VariableExpression); ok {
 			name := v.Name
-			return AssignmentExpression{Name: name, Value: value}
+			return AssignmentExpression{Name: name, Value: value, Depth: GlobalDepth}
 		}
 
-		errorHandler.RaiseError(errorHandler.CodeSyntaxError, "Lado izquierdo de asignación inválido.", equals.Line, "Cocinado", true)
+		errorAt(equals, "Lado izquierdo de asignación inválido.", "[Cocinado]")
+	}
+
+	return expr
+}
+
+func conditional() Expression {
+	expr := coalescing()
+
+	if match(lexer.TokenQuestion) {
+		question := previous()
+		thenBranch := expression()
+		consume(lexer.TokenColon, "Se esperaba un : en la expresión condicional.")
+		elseBranch := conditional()
+		expr = ConditionalExpression{expr, question, thenBranch, elseBranch}
+	}
+
+	return expr
+}
+
+// Null coalescing short-circuits just like "y" and "o", so it is a LogicalExpression too
+func coalescing() Expression {
+	expr := or()
+
+	for match(lexer.TokenNullCoalescing) {
+		operator := previous()
+		right := or()
+		expr = LogicalExpression{expr, operator, right}
 	}
 
 	return expr
@@ -503,17 +848,35 @@ func equality() Expression {
 }
 
 func comparison() Expression {
-	expr := addition()
+	expr := rangeExpression()
 
 	for match(lexer.TokenGreaterThan, lexer.TokenGreaterEqual, lexer.TokenLessThan, lexer.TokenLessEqual) {
 		operator := previous()
-		right := addition()
+		right := rangeExpression()
 		expr = BinaryExpression{Left: expr, Operator: operator, Right: right}
 	}
 
 	return expr
 }
 
+func rangeExpression() Expression {
+	expr := addition()
+
+	if match(lexer.TokenDotDot) {
+		operator := previous()
+		end := addition()
+
+		var step Expression
+		if match(lexer.TokenStep) {
+			step = addition()
+		}
+
+		expr = RangeExpression{Start: expr, Operator: operator, End: end, Step: step}
+	}
+
+	return expr
+}
+
 func addition() Expression {
 	expr := multiplication()
 
@@ -557,6 +920,21 @@ func unary() Expression {
 		return UnaryExpression{Operator: operator, Right: right}
 	}
 
+	return spawn()
+}
+
+func spawn() Expression {
+	if match(lexer.TokenSpawn) {
+		keyword := previous()
+		expr := call()
+
+		if c, ok := expr.(CallExpression); ok {
+			return SpawnExpression{keyword, c}
+		}
+
+		errorAt(keyword, "Solo se pueden lanzar llamadas a funciones.", "[Cocinado]")
+	}
+
 	return call()
 }
 
@@ -591,23 +969,23 @@ func finishCall(callee Expression) Expression {
 
 func primary() Expression {
 	if match(lexer.TokenFalse) {
-		return LiteralExpression{Value: false}
+		return LiteralExpression{Value: false, Token: previous()}
 	}
 
 	if match(lexer.TokenTrue) {
-		return LiteralExpression{Value: true}
+		return LiteralExpression{Value: true, Token: previous()}
 	}
 
 	if match(lexer.TokenNull) {
-		return LiteralExpression{Value: nil}
+		return LiteralExpression{Value: nil, Token: previous()}
 	}
 
 	if match(lexer.TokenIdentifier) {
-		return VariableExpression{previous()}
+		return VariableExpression{Name: previous(), Depth: GlobalDepth}
 	}
 
 	if match(lexer.TokenNumber, lexer.TokenString) {
-		return LiteralExpression{Value: previous().Literal}
+		return LiteralExpression{Value: previous().Literal, Token: previous()}
 	}
 
 	if match(lexer.TokenLeftParentheses) {
@@ -616,7 +994,7 @@ func primary() Expression {
 		return GroupingExpression{expr}
 	}
 
-	errorHandler.RaiseError(errorHandler.CodeSyntaxError, fmt.Sprintf("Elemento desconocido: %v", peek().Lexeme), peek().Line, "[Cocinado]", true)
+	errorAt(peek(), fmt.Sprintf("Elemento desconocido: %v (%v)", peek().Lexeme, lexer.TokenName(peek().TokenType)), "[Cocinado]")
 	return nil
 }
 
@@ -627,10 +1005,25 @@ func consume(tokenType int, message string) lexer.Token {
 
 	token := peek()
 
-	errorHandler.RaiseError(errorHandler.CodeSyntaxError, message, token.Line, token.Lexeme, true)
+	// The end of the file has no lexeme worth showing
+	context := token.Lexeme
+	if token.TokenType == lexer.TokenEOF {
+		context = lexer.TokenName(token.TokenType)
+	}
+
+	errorAt(token, message, context)
 	panic("consume error")
 }
 
+// errorAt reports a syntax error about a token, so tools can point at it
+func errorAt(token lexer.Token, message string, context string) {
+	length := len([]rune(token.Lexeme))
+	if token.TokenType == lexer.TokenEOF {
+		length = 0
+	}
+	errorHandler.RaiseErrorAt(errorHandler.CodeSyntaxError, message, token.Line, token.Column, length, context, true)
+}
+
 func match(types ...int) bool {
 	for _, v := range types {
 		if check(v) {
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"clase-mates-computacionales/cazuela/console"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/interpreter"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+	"clase-mates-computacionales/cazuela/values"
+	"clase-mates-computacionales/cazuela/vm"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// The file the REPL keeps its history in, in the home folder
+const historyFile = ".cazuela_historia"
+
+func startLineInterpreter() {
+	errorHandler.IgnoreFatals = true
+	interpreter.ShouldPrintAllExpressions = true
+	vm.ShouldPrintAllExpressions = true
+
+	go handleInterrupts()
+
+	historyPath := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		historyPath = filepath.Join(home, historyFile)
+	}
+
+	c := console.New(historyPath)
+	c.Prompt = "<Cazuela># "
+	c.Continuation = "<Cazuela>. "
+
+	for {
+		// Both the end of the input and Ctrl-C with nothing typed leave the REPL
+		input, err := c.ReadInput()
+		if err != nil {
+			return
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(input), ":") {
+			runMetaCommand(strings.TrimSpace(input))
+		} else {
+			executeCancellable(input)
+		}
+
+		if errorHandler.HasExited {
+			os.Exit(errorHandler.ExitCode())
+		}
+	}
+}
+
+// A metaCommand is a REPL command about the session rather than Cazuela code, typed
+// after a colon. It gets the rest of the input
+type metaCommand struct {
+	name        string
+	arguments   string
+	description string
+	run         func(argument string)
+}
+
+var metaCommands []metaCommand
+
+func init() {
+	metaCommands = []metaCommand{
+		{"vars", "", "lista las variables globales, con su tipo y su valor", showGlobals},
+		{"ast", "código", "muestra el árbol que resulta de leer el código", showAST},
+		{"tokens", "código", "muestra los tokens del código", showTokens},
+		{"load", "archivo", "ejecuta un archivo, dejando lo que defina en la sesión", loadFile},
+		{"reset", "", "borra todo lo definido en la sesión", resetSession},
+		{"time", "código", "ejecuta el código, mostrando cuánto tardó", timeExecution},
+		{"help", "", "muestra esta ayuda", showMetaCommands},
+	}
+}
+
+func runMetaCommand(input string) {
+	name, argument := input[1:], ""
+	if i := strings.IndexAny(name, " \t\n"); i >= 0 {
+		name, argument = name[:i], strings.TrimSpace(name[i:])
+	}
+
+	for _, c := range metaCommands {
+		if c.name == name {
+			c.run(argument)
+			return
+		}
+	}
+
+	fmt.Printf("Comando desconocido: :%v, :help muestra los que hay\n", name)
+}
+
+func showMetaCommands(argument string) {
+	fmt.Println("Además de código, la consola entiende estos comandos:")
+	for _, c := range metaCommands {
+		fmt.Printf("  :%-18v %v\n", strings.TrimSpace(c.name+" "+c.arguments), c.description)
+	}
+}
+
+// showGlobals lists the global variables of the engine in use, natives and constants included
+func showGlobals(argument string) {
+	var names []string
+	var globals []interface{}
+
+	if options.vm {
+		names, globals = vm.Globals()
+	} else {
+		names, globals = interpreter.Globals().Snapshot()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, name := range names {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", name, values.TypeName(globals[i]), globals[i])
+	}
+	w.Flush()
+}
+
+// asStatement adds the semicolon a piece of code typed on its own usually lacks
+func asStatement(code string) string {
+	if strings.HasSuffix(code, ";") || strings.HasSuffix(code, "}") {
+		return code
+	}
+	return code + ";"
+}
+
+func showAST(code string) {
+	errorHandler.HasFatalled = false
+
+	tokens := lexer.GetTokens(asStatement(code))
+	if errorHandler.HasFatalled {
+		return
+	}
+
+	statements := parser.Parse(tokens)
+	if errorHandler.HasFatalled {
+		return
+	}
+
+	fmt.Println(parser.Dump(statements))
+}
+
+// showTokens shows the code as the lexer sees it, without adding anything to it
+func showTokens(code string) {
+	errorHandler.HasFatalled = false
+	printTokens(lexer.GetTokens(code))
+}
+
+// loadFile runs a file as a program would run, without echoing the value of its expressions
+func loadFile(path string) {
+	if path == "" {
+		fmt.Println("Uso: :load archivo")
+		return
+	}
+
+	source, ok := loadSource(path)
+	if !ok {
+		return
+	}
+
+	interpreter.ShouldPrintAllExpressions = false
+	vm.ShouldPrintAllExpressions = false
+	defer func() {
+		interpreter.ShouldPrintAllExpressions = true
+		vm.ShouldPrintAllExpressions = true
+	}()
+
+	executeCancellable(source)
+}
+
+func resetSession(argument string) {
+	initEnv()
+	fmt.Println("Se reinició la sesión")
+}
+
+func timeExecution(code string) {
+	start := time.Now()
+	executeCancellable(asStatement(code))
+	fmt.Printf("Tardó %v\n", time.Since(start))
+}
+
+// The evaluation the REPL is running, if any, so Ctrl-C can cancel it
+var running struct {
+	sync.Mutex
+	cancel context.CancelFunc
+}
+
+func executeCancellable(command string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	running.Lock()
+	running.cancel = cancel
+	running.Unlock()
+
+	execute(ctx, command)
+
+	running.Lock()
+	running.cancel = nil
+	running.Unlock()
+}
+
+// Ctrl-C cancels the evaluation being run, or leaves the REPL when waiting for input
+func handleInterrupts() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	for range interrupts {
+		running.Lock()
+		cancel := running.cancel
+		running.Unlock()
+
+		if cancel == nil {
+			fmt.Println()
+			os.Exit(0)
+		}
+
+		cancel()
+	}
+}
@@ -0,0 +1,244 @@
+package resolver
+
+import (
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/parser"
+)
+
+/*
+Works out, before running, where every local variable will live, so the interpreter
+can reach it by position instead of looking its name up scope by scope.
+
+The scopes opened here must match the environments the interpreter creates one to
+one: a block, a function call (parameters and body share it) and every iteration
+of a "por cada" (holding just the variable). Variables not found in any of them
+are globals.
+
+A function may call another declared further down the same block, as long as it runs
+after both are declared. The slot of that one isn't known yet when the call is resolved,
+so it gets UnknownSlot and the interpreter looks it up by name in its scope.
+*/
+
+type scope struct {
+	slots     map[string]int
+	count     int
+	functions map[string]bool
+}
+
+var scopes []*scope
+
+// The first scope of the function being resolved, from where on functions declared
+// further down are not visible yet
+var functionStart int
+
+// The globals a program declares and uses, for Undefined
+var (
+	globalNames map[string]bool
+	globalUses  []lexer.Token
+)
+
+// Resolve returns a copy of the AST with the depth and slot of every local variable filled in
+func Resolve(stmts []parser.Stmt) []parser.Stmt {
+	scopes, functionStart = nil, 0
+	globalNames, globalUses = make(map[string]bool), nil
+	return resolveStatements(stmts)
+}
+
+// Undefined lists the variables a program uses without declaring them anywhere, neither
+// as locals nor as globals, leaving out those isPredefined tells the engine defines. Each
+// one is given once, where it is first used
+func Undefined(stmts []parser.Stmt, isPredefined func(name string) bool) []lexer.Token {
+	Resolve(stmts)
+
+	var undefined []lexer.Token
+	reported := make(map[string]bool)
+	for _, name := range globalUses {
+		if globalNames[name.Lexeme] || reported[name.Lexeme] || isPredefined(name.Lexeme) {
+			continue
+		}
+		reported[name.Lexeme] = true
+		undefined = append(undefined, name)
+	}
+	return undefined
+}
+
+func beginScope() {
+	scopes = append(scopes, &scope{slots: make(map[string]int), functions: make(map[string]bool)})
+}
+
+func endScope() {
+	scopes = scopes[:len(scopes)-1]
+}
+
+// declare mirrors Environment.Define, which gives local variables the next free slot
+func declare(name string) {
+	if len(scopes) == 0 {
+		globalNames[name] = true
+		return
+	}
+
+	current := scopes[len(scopes)-1]
+	current.slots[name] = current.count
+	current.count++
+}
+
+func lookup(name lexer.Token) (int, int) {
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if slot, ok := scopes[i].slots[name.Lexeme]; ok {
+			return len(scopes) - 1 - i, slot
+		}
+		if i < functionStart && scopes[i].functions[name.Lexeme] {
+			return len(scopes) - 1 - i, parser.UnknownSlot
+		}
+	}
+
+	globalUses = append(globalUses, name)
+	return parser.GlobalDepth, 0
+}
+
+// The functions of a list of statements are noted before resolving any of them, so the
+// functions nested in them can call those declared further down
+func resolveStatements(stmts []parser.Stmt) []parser.Stmt {
+	if len(scopes) > 0 {
+		current := scopes[len(scopes)-1]
+		for _, s := range stmts {
+			if v, ok := s.(parser.FnDecl); ok {
+				current.functions[v.Name.Lexeme] = true
+			}
+		}
+	}
+
+	resolved := make([]parser.Stmt, len(stmts))
+	for i, s := range stmts {
+		resolved[i] = resolveStatement(s)
+	}
+	return resolved
+}
+
+func resolveStatement(s parser.Stmt) parser.Stmt {
+	if v, ok := s.(parser.Statement); ok {
+		v.Expr = resolveExpression(v.Expr)
+		return v
+	} else if v, ok := s.(parser.Print); ok {
+		v.Expr = resolveExpression(v.Expr)
+		return v
+	} else if v, ok := s.(parser.Declaration); ok {
+		// The initializer is resolved first, so it still sees any outer variable with the same name
+		v.Initializer = resolveExpression(v.Initializer)
+		declare(v.Name.Lexeme)
+		return v
+	} else if v, ok := s.(parser.Block); ok {
+		beginScope()
+		defer endScope()
+		return parser.Block{Statements: resolveStatements(v.Statements)}
+	} else if v, ok := s.(parser.If); ok {
+		v.Condition = resolveExpression(v.Condition)
+		v.ThenBranch = resolveStatement(v.ThenBranch)
+		v.ElseBranch = resolveStatement(v.ElseBranch)
+		return v
+	} else if v, ok := s.(parser.While); ok {
+		v.Condition = resolveExpression(v.Condition)
+		v.Body = resolveStatement(v.Body)
+		v.Increment = resolveExpression(v.Increment)
+		return v
+	} else if v, ok := s.(parser.FnDecl); ok {
+		declare(v.Name.Lexeme)
+		return resolveFunction(v)
+	} else if v, ok := s.(parser.ForEach); ok {
+		v.Iterable = resolveExpression(v.Iterable)
+		beginScope()
+		defer endScope()
+		declare(v.Variable.Lexeme)
+		v.Body = resolveStatement(v.Body)
+		return v
+	} else if v, ok := s.(parser.Switch); ok {
+		v.Subject = resolveExpression(v.Subject)
+		cases := make([]parser.SwitchCase, len(v.Cases))
+		for i, c := range v.Cases {
+			cases[i] = parser.SwitchCase{Patterns: resolveExpressions(c.Patterns), Body: resolveStatement(c.Body)}
+		}
+		v.Cases = cases
+		v.Default = resolveStatement(v.Default)
+		return v
+	} else if v, ok := s.(parser.ReturnStmt); ok {
+		v.Value = resolveExpression(v.Value)
+		return v
+	} else if v, ok := s.(parser.YieldStmt); ok {
+		v.Value = resolveExpression(v.Value)
+		return v
+	}
+
+	return s
+}
+
+// The parameters, including the empty one the parser reserves, take the first slots of the call's scope
+func resolveFunction(v parser.FnDecl) parser.FnDecl {
+	beginScope()
+	defer endScope()
+
+	enclosingStart := functionStart
+	functionStart = len(scopes) - 1
+	defer func() { functionStart = enclosingStart }()
+
+	for _, parameter := range v.Parameters {
+		declare(parameter.Lexeme)
+	}
+
+	v.Body = resolveStatements(v.Body)
+	return v
+}
+
+func resolveExpressions(exprs []parser.Expression) []parser.Expression {
+	resolved := make([]parser.Expression, len(exprs))
+	for i, e := range exprs {
+		resolved[i] = resolveExpression(e)
+	}
+	return resolved
+}
+
+func resolveExpression(expr parser.Expression) parser.Expression {
+	if v, ok := expr.(parser.VariableExpression); ok {
+		v.Depth, v.Slot = lookup(v.Name)
+		return v
+	} else if v, ok := expr.(parser.AssignmentExpression); ok {
+		v.Value = resolveExpression(v.Value)
+		v.Depth, v.Slot = lookup(v.Name)
+		return v
+	} else if v, ok := expr.(parser.GroupingExpression); ok {
+		return parser.GroupingExpression{Expression: resolveExpression(v.Expression)}
+	} else if v, ok := expr.(parser.UnaryExpression); ok {
+		v.Right = resolveExpression(v.Right)
+		return v
+	} else if v, ok := expr.(parser.BinaryExpression); ok {
+		v.Left = resolveExpression(v.Left)
+		v.Right = resolveExpression(v.Right)
+		return v
+	} else if v, ok := expr.(parser.LogicalExpression); ok {
+		v.Left = resolveExpression(v.Left)
+		v.Right = resolveExpression(v.Right)
+		return v
+	} else if v, ok := expr.(parser.ConditionalExpression); ok {
+		v.Condition = resolveExpression(v.Condition)
+		v.ThenBranch = resolveExpression(v.ThenBranch)
+		v.ElseBranch = resolveExpression(v.ElseBranch)
+		return v
+	} else if v, ok := expr.(parser.RangeExpression); ok {
+		v.Start = resolveExpression(v.Start)
+		v.End = resolveExpression(v.End)
+		v.Step = resolveExpression(v.Step)
+		return v
+	} else if v, ok := expr.(parser.CallExpression); ok {
+		return resolveCall(v)
+	} else if v, ok := expr.(parser.SpawnExpression); ok {
+		v.Call = resolveCall(v.Call)
+		return v
+	}
+
+	return expr
+}
+
+func resolveCall(v parser.CallExpression) parser.CallExpression {
+	v.Callee = resolveExpression(v.Callee)
+	v.Arguments = resolveExpressions(v.Arguments)
+	return v
+}
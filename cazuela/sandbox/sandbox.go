@@ -0,0 +1,154 @@
+package sandbox
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Limits on what a program may do, for running code that can't be trusted, such as
+scripts handed in by students. Both the interpreter and the VM enforce them, ending
+the program with an error code of its own for each limit.
+
+Steps are statements and loop iterations in the interpreter, and instructions in the
+VM. Every thread counts its own and adds them to the shared total in batches of up to
+CheckInterval, which is also how often the time limit and the context of the run are
+checked, so the time limit or a cancellation may be noticed up to that many steps late.
+Batches get smaller as the step limit gets close, so a program running on a single
+thread is stopped on the very step that goes past it; tasks running at the same time
+may take a few more between them.
+
+Tasks still running when the run is over are stopped quietly, as they would be if the
+program had exited.
+*/
+
+// CheckInterval is how many steps a thread takes between checks of the budget
+const CheckInterval = 256
+
+// Limits bounds the run of a program. The zero value of every field means no limit
+type Limits struct {
+	MaxSteps        int64
+	Timeout         time.Duration
+	MaxStringLength int
+	// Natives lists the native functions that may be called, nil allows all of them
+	Natives []string
+}
+
+// A Budget keeps track of a run of a program against its Limits and its context.
+// It is shared by every thread of the run. A nil Budget allows everything
+type Budget struct {
+	limits   Limits
+	natives  map[string]bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	steps    int64
+	finished int32
+}
+
+// NewBudget starts the budget of a run that stops when ctx is done, counting its time from now
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	b := &Budget{limits: limits}
+
+	if limits.Timeout > 0 {
+		b.ctx, b.cancel = context.WithTimeout(ctx, limits.Timeout)
+	} else {
+		b.ctx, b.cancel = context.WithCancel(ctx)
+	}
+
+	if limits.Natives != nil {
+		b.natives = make(map[string]bool)
+		for _, name := range limits.Natives {
+			b.natives[name] = true
+		}
+	}
+
+	return b
+}
+
+// Release ends the run, stopping any task still running
+func (b *Budget) Release() {
+	if b != nil {
+		atomic.StoreInt32(&b.finished, 1)
+		b.cancel()
+	}
+}
+
+// Finished reports whether the run is over, so what is left of it must stop without complaining
+func (b *Budget) Finished() bool {
+	return b != nil && atomic.LoadInt32(&b.finished) == 1
+}
+
+// Done is closed once the run has to stop, for threads blocked waiting on something else
+func (b *Budget) Done() <-chan struct{} {
+	if b == nil {
+		return nil
+	}
+	return b.ctx.Done()
+}
+
+// Interval is how many steps a thread may take before spending them: CheckInterval, or
+// fewer when that many would go past the step limit
+func (b *Budget) Interval() int64 {
+	if b == nil || b.limits.MaxSteps <= 0 {
+		return CheckInterval
+	}
+
+	left := b.limits.MaxSteps - atomic.LoadInt64(&b.steps) + 1
+	switch {
+	case left < 1:
+		return 1
+	case left < CheckInterval:
+		return left
+	}
+	return CheckInterval
+}
+
+// Spend adds steps taken by a thread, returning the code and message of the limit
+// that has been exceeded, if any
+func (b *Budget) Spend(steps int64) (int, string) {
+	if b == nil {
+		return errorHandler.CodeAllGood, ""
+	}
+
+	total := atomic.AddInt64(&b.steps, steps)
+	if b.limits.MaxSteps > 0 && total > b.limits.MaxSteps {
+		return errorHandler.CodeStepLimit, fmt.Sprintf("Se superó el límite de %d pasos", b.limits.MaxSteps)
+	}
+
+	return b.Expired()
+}
+
+// Expired checks whether the time is up or the run has been cancelled
+func (b *Budget) Expired() (int, string) {
+	if b == nil {
+		return errorHandler.CodeAllGood, ""
+	}
+
+	switch b.ctx.Err() {
+	case context.DeadlineExceeded:
+		return errorHandler.CodeTimeLimit, fmt.Sprintf("Se superó el límite de tiempo de %v", b.limits.Timeout)
+	case context.Canceled:
+		return errorHandler.CodeCancelled, "Se canceló la ejecución"
+	}
+
+	return errorHandler.CodeAllGood, ""
+}
+
+// CheckString checks a string the program is about to create
+func (b *Budget) CheckString(s string) (int, string) {
+	if b == nil || b.limits.MaxStringLength <= 0 || len(s) <= b.limits.MaxStringLength {
+		return errorHandler.CodeAllGood, ""
+	}
+	return errorHandler.CodeSizeLimit, fmt.Sprintf("La cadena supera el límite de %d bytes", b.limits.MaxStringLength)
+}
+
+// CheckNative checks that a native function may be called
+func (b *Budget) CheckNative(name string) (int, string) {
+	if b == nil || b.natives == nil || b.natives[name] {
+		return errorHandler.CodeAllGood, ""
+	}
+	return errorHandler.CodeForbiddenNative, fmt.Sprintf("No se permite llamar a %v", name)
+}
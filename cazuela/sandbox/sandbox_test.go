@@ -0,0 +1,91 @@
+package sandbox
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"context"
+	"testing"
+)
+
+// TestIntervalReachesTheLimit spends steps the way the engines do, checking the step
+// that goes past the limit is the one reported
+func TestIntervalReachesTheLimit(t *testing.T) {
+	for _, max := range []int64{1, 2, 10, CheckInterval - 1, CheckInterval, CheckInterval + 1, 1000} {
+		b := NewBudget(context.Background(), Limits{MaxSteps: max})
+
+		var taken, pending, next int64
+		for taken <= max+1 {
+			taken++
+			pending++
+			if pending < next {
+				continue
+			}
+
+			code, _ := b.Spend(pending)
+			pending, next = 0, b.Interval()
+			if code != errorHandler.CodeAllGood {
+				break
+			}
+		}
+
+		if taken != max+1 {
+			t.Errorf("con un límite de %d pasos se notó en el paso %d", max, taken)
+		}
+		b.Release()
+	}
+}
+
+func TestChecks(t *testing.T) {
+	b := NewBudget(context.Background(), Limits{MaxStringLength: 3, Natives: []string{"canal"}})
+	defer b.Release()
+
+	if code, _ := b.CheckString("abc"); code != errorHandler.CodeAllGood {
+		t.Errorf("una cadena dentro del límite dio el código %X", code)
+	}
+	if code, _ := b.CheckString("abcd"); code != errorHandler.CodeSizeLimit {
+		t.Errorf("una cadena demasiado larga dio el código %X", code)
+	}
+	if code, _ := b.CheckNative("canal"); code != errorHandler.CodeAllGood {
+		t.Errorf("una nativa permitida dio el código %X", code)
+	}
+	if code, _ := b.CheckNative("salir"); code != errorHandler.CodeForbiddenNative {
+		t.Errorf("una nativa no permitida dio el código %X", code)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewBudget(ctx, Limits{})
+
+	if code, _ := b.Expired(); code != errorHandler.CodeAllGood || b.Finished() {
+		t.Fatalf("un presupuesto recién creado dio el código %X", code)
+	}
+
+	cancel()
+	<-b.Done()
+	if code, _ := b.Expired(); code != errorHandler.CodeCancelled {
+		t.Errorf("al cancelar el contexto se esperaba el código %X, se obtuvo %X", errorHandler.CodeCancelled, code)
+	}
+	if b.Finished() {
+		t.Error("cancelar el contexto no termina la ejecución, solo Release lo hace")
+	}
+
+	b.Release()
+	if !b.Finished() {
+		t.Error("Release no terminó la ejecución")
+	}
+}
+
+func TestNilBudget(t *testing.T) {
+	var b *Budget
+
+	if code, _ := b.Spend(1 << 40); code != errorHandler.CodeAllGood {
+		t.Errorf("un presupuesto nil dio el código %X", code)
+	}
+	if code, _ := b.CheckNative("salir"); code != errorHandler.CodeAllGood {
+		t.Errorf("un presupuesto nil no permitió una nativa: %X", code)
+	}
+	if b.Interval() != CheckInterval || b.Done() != nil || b.Finished() {
+		t.Error("un presupuesto nil no se comporta como uno sin límites")
+	}
+	b.Release()
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"clase-mates-computacionales/cazuela/dap"
+	"clase-mates-computacionales/cazuela/debugger"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/formatter"
+	"clase-mates-computacionales/cazuela/interpreter"
+	"clase-mates-computacionales/cazuela/lexer"
+	"clase-mates-computacionales/cazuela/lsp"
+	"clase-mates-computacionales/cazuela/parser"
+	"clase-mates-computacionales/cazuela/resolver"
+	"clase-mates-computacionales/cazuela/vm"
+	"clase-mates-computacionales/utilities"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// checkCommand lexes, parses and resolves programs without running them, reporting
+// every error found, variables used without being declared included. It exits with
+// the code of the last one
+func checkCommand(args []string) int {
+	flags := newFlags("check", "archivos")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		return invalidUsage(flags)
+	}
+
+	// An error in one file must not keep the rest from being checked
+	errorHandler.IgnoreFatals = true
+	code := errorHandler.CodeAllGood
+	interpreter.InitEnv()
+
+	for _, path := range flags.Args() {
+		source, ok := loadSource(path)
+		if !ok {
+			code = errorHandler.CodeFileError
+			continue
+		}
+
+		errorHandler.HasFatalled = false
+		tokens := lexer.GetTokens(source)
+		if !errorHandler.HasFatalled {
+			statements := parser.Parse(tokens)
+			if !errorHandler.HasFatalled {
+				for _, name := range resolver.Undefined(statements, isPredefined) {
+					errorHandler.RaiseError(errorHandler.CodeUndefinedVariable, fmt.Sprintf("Variable %v no definida", name.Lexeme), name.Line, "[Resolución]", true)
+				}
+			}
+		}
+
+		if errorHandler.HasFatalled {
+			fmt.Fprintf(errorHandler.Output, "%v tiene errores\n", path)
+			code = errorHandler.ExitCode()
+		}
+	}
+
+	return code
+}
+
+// isPredefined tells whether a global is one of those the interpreter defines on its own,
+// once InitEnv has run
+func isPredefined(name string) bool {
+	_, ok := interpreter.Globals().Find(name)
+	return ok
+}
+
+// tokensCommand shows what the lexer makes of a program, one token per line or with -json as JSON
+func tokensCommand(args []string) int {
+	flags := newFlags("tokens", "[-json] archivo")
+	asJSON := flags.Bool("json", false, "mostrar los tokens como JSON")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 1 {
+		return invalidUsage(flags)
+	}
+
+	source, ok := loadSource(flags.Arg(0))
+	if !ok {
+		return errorHandler.CodeFileError
+	}
+	tokens := lexer.GetTokens(source)
+
+	if *asJSON {
+		type jsonToken struct {
+			Type    string      `json:"type"`
+			Lexeme  string      `json:"lexeme"`
+			Literal interface{} `json:"literal"`
+			Line    int         `json:"line"`
+			Column  int         `json:"column"`
+		}
+
+		described := make([]jsonToken, len(tokens))
+		for i, t := range tokens {
+			described[i] = jsonToken{lexer.TokenName(t.TokenType), t.Lexeme, t.Literal, t.Line, t.Column}
+		}
+		utilities.PrettyPrint(described)
+		return errorHandler.CodeAllGood
+	}
+
+	printTokens(tokens)
+	return errorHandler.CodeAllGood
+}
+
+// printTokens shows one token per line, with its position, type, lexeme and literal
+func printTokens(tokens []lexer.Token) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, t := range tokens {
+		fmt.Fprintf(w, "%d:%d\t%v\t%v", t.Line, t.Column, lexer.TokenName(t.TokenType), t.Lexeme)
+		if t.Literal != nil {
+			fmt.Fprintf(w, "\t%#v", t.Literal)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+// astCommand shows what the parser makes of a program, as an S-expression or with -json as JSON
+func astCommand(args []string) int {
+	flags := newFlags("ast", "[-json] archivo")
+	asJSON := flags.Bool("json", false, "mostrar el árbol como JSON, con el tipo y la posición de cada nodo")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 1 {
+		return invalidUsage(flags)
+	}
+
+	source, ok := loadSource(flags.Arg(0))
+	if !ok {
+		return errorHandler.CodeFileError
+	}
+	tokens := lexer.GetTokens(source)
+	statements := parser.Parse(tokens)
+
+	if *asJSON {
+		data, err := parser.EncodeJSON(statements)
+		utilities.AssertError(err)
+		fmt.Println(string(data))
+	} else {
+		fmt.Println(parser.Dump(statements))
+	}
+	return errorHandler.CodeAllGood
+}
+
+// fmtCommand shows programs in the canonical style, or writes them back with -w. With
+// -check it only lists the ones that aren't, exiting with CodeUnformatted if there are
+// any. A file that can't be read or has errors takes precedence with its own code
+func fmtCommand(args []string) int {
+	flags := newFlags("fmt", "[-w] [-check] archivos")
+	write := flags.Bool("w", false, "escribir el resultado en el archivo en lugar de mostrarlo")
+	check := flags.Bool("check", false, "solo listar los archivos sin formato, terminando con error si hay alguno")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		return invalidUsage(flags)
+	}
+
+	// A file with errors must not keep the rest from being formatted, nor be mixed with them
+	errorHandler.IgnoreFatals = true
+	errorHandler.Output = os.Stderr
+	code := errorHandler.CodeAllGood
+
+	for _, path := range flags.Args() {
+		source, ok := loadSource(path)
+		if !ok {
+			code = errorHandler.CodeFileError
+			continue
+		}
+
+		formatted, ok := formatter.Format(source)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%v no se formateó por tener errores\n", path)
+			code = errorHandler.ExitCode()
+			continue
+		}
+
+		switch {
+		case *check:
+			if formatted != source {
+				fmt.Println(path)
+				if code == errorHandler.CodeAllGood {
+					code = errorHandler.CodeUnformatted
+				}
+			}
+		case *write && path == stdinPath:
+			fmt.Print(formatted)
+		case *write:
+			if formatted != source {
+				if err := writeSource(path, formatted); err != nil {
+					errorHandler.RaiseError(errorHandler.CodeFileError, err.Error(), 0, "["+path+"]", false)
+					code = errorHandler.CodeFileError
+				}
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+
+	return code
+}
+
+// writeSource replaces the contents of a file, keeping its permissions
+func writeSource(path string, source string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(source), info.Mode())
+}
+
+// testCommand runs every program with a file of the same name ending in .salida next to
+// it, comparing what the program writes, errors included, with the contents of that file.
+// Folders are searched for such programs, the current one if none is given
+func testCommand(args []string) int {
+	flags := runFlags("test", "[opciones] [archivos o carpetas]")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	applyRunFlags(flags)
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	var programs []string
+	for _, path := range paths {
+		found, err := findTests(path)
+		if err != nil {
+			errorHandler.RaiseError(errorHandler.CodeFileError, err.Error(), 0, "["+path+"]", false)
+			return errorHandler.CodeFileError
+		}
+		programs = append(programs, found...)
+	}
+
+	// Every program runs to its end, whatever errors the rest had
+	errorHandler.IgnoreFatals = true
+	failed := 0
+
+	for _, program := range programs {
+		if !runTest(program) {
+			failed++
+		}
+	}
+
+	fmt.Printf("%d pruebas, %d fallaron\n", len(programs), failed)
+	if failed > 0 {
+		return errorHandler.CodeTestFailed
+	}
+	return errorHandler.CodeAllGood
+}
+
+// expectedOutput is the file holding what a program should write
+func expectedOutput(program string) string {
+	return strings.TrimSuffix(program, ".caz") + ".salida"
+}
+
+// findTests lists the programs to test in a path. A program named on its own is tested
+// even without its .salida, so the missing file is reported
+func findTests(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var programs []string
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(file) != ".caz" {
+			return nil
+		}
+		if _, err := os.Stat(expectedOutput(file)); err == nil {
+			programs = append(programs, file)
+		}
+		return nil
+	})
+	return programs, err
+}
+
+// runTest runs a program in a fresh environment, telling whether it wrote what was expected
+func runTest(program string) bool {
+	expected, err := ioutil.ReadFile(expectedOutput(program))
+	if err != nil {
+		fmt.Printf("FALLO %v: %v\n", program, err)
+		return false
+	}
+
+	source, err := ioutil.ReadFile(program)
+	if err != nil {
+		fmt.Printf("FALLO %v: %v\n", program, err)
+		return false
+	}
+
+	got := strings.Split(normalizeNewlines(captureOutput(string(source))), "\n")
+	want := strings.Split(normalizeNewlines(string(expected)), "\n")
+
+	for i := 0; i < len(got) || i < len(want); i++ {
+		var gotLine, wantLine string
+		if i < len(got) {
+			gotLine = got[i]
+		}
+		if i < len(want) {
+			wantLine = want[i]
+		}
+
+		if i >= len(got) || i >= len(want) || gotLine != wantLine {
+			fmt.Printf("FALLO %v: línea %d\n\tse esperaba: %q\n\tse obtuvo:   %q\n", program, i+1, wantLine, gotLine)
+			return false
+		}
+	}
+
+	fmt.Printf("ok    %v\n", program)
+	return true
+}
+
+// captureOutput runs a program in a fresh environment, giving what it wrote, errors included
+func captureOutput(source string) string {
+	var output bytes.Buffer
+	interpreter.Output, vm.Output, errorHandler.Output = &output, &output, &output
+	defer func() {
+		interpreter.Output, vm.Output, errorHandler.Output = os.Stdout, os.Stdout, os.Stdout
+	}()
+
+	initEnv()
+	execute(context.Background(), source)
+	return output.String()
+}
+
+func normalizeNewlines(text string) string {
+	return strings.ReplaceAll(text, "\r\n", "\n")
+}
+
+// debugCommand runs a file under the debugger, which always uses the tree-walking interpreter
+func debugCommand(args []string) int {
+	flags := newFlags("depurar", "archivo")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 1 {
+		return invalidUsage(flags)
+	}
+
+	source, ok := loadSource(flags.Arg(0))
+	if !ok {
+		return errorHandler.CodeFileError
+	}
+
+	tokens := lexer.GetTokens(source)
+	if errorHandler.HasFatalled {
+		return errorHandler.ExitCode()
+	}
+
+	statements := parser.Parse(tokens)
+	if errorHandler.HasFatalled {
+		return errorHandler.ExitCode()
+	}
+
+	interpreter.InitEnv()
+
+	d := debugger.New(source, os.Stdin, os.Stdout)
+	interpreter.DebugHook = d.Hook
+
+	// Errors are reported without ending the session, so the user can keep looking around
+	errorHandler.IgnoreFatals = true
+
+	interpreter.Interpret(context.Background(), statements)
+	d.Finish()
+	return errorHandler.ExitCode()
+}
+
+// dapCommand lets an editor debug a program through the Debug Adapter Protocol on stdio
+func dapCommand(args []string) int {
+	flags := newFlags("dap", "")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 0 {
+		return invalidUsage(flags)
+	}
+
+	server := dap.NewServer(os.Stdin, os.Stdout)
+	if err := server.Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorHandler.CodeFileError
+	}
+	return errorHandler.CodeAllGood
+}
+
+// lspCommand gives editors diagnostics and navigation through the Language Server Protocol on stdio
+func lspCommand(args []string) int {
+	flags := newFlags("lsp", "")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() != 0 {
+		return invalidUsage(flags)
+	}
+
+	server := lsp.NewServer(os.Stdin, os.Stdout)
+	if err := server.Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return errorHandler.CodeFileError
+	}
+	return errorHandler.CodeAllGood
+}
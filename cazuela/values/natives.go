@@ -0,0 +1,178 @@
+package values
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/sandbox"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Arguments are those the program was given after its path, it reads them from "argumentos"
+var Arguments []string
+
+// A Thread is the thread of an engine a native runs on, giving it what it needs from the engine
+type Thread interface {
+	// Budget is that of the program being run
+	Budget() *sandbox.Budget
+
+	// Fail reports an error in the native, from the line that called it, and unwinds the thread
+	Fail(code int, message string, context string)
+
+	// Stop unwinds the thread without reporting anything, when the program has to end
+	Stop()
+
+	// Scheduler is that of the run the thread is part of
+	Scheduler() *Scheduler
+
+	// Task reports whether the thread runs a task, rather than the main program or a
+	// generator it drives. Only the main program is told when every thread is waiting
+	Task() bool
+}
+
+// A Native is a function provided by the engines themselves. It gets the arguments of
+// the call, already checked to be Arity of them
+type Native struct {
+	Name     string
+	Arity    int
+	Function func(Thread, []interface{}) interface{}
+}
+
+func (n *Native) String() string {
+	return fmt.Sprintf("<nativa %v>", n.Name)
+}
+
+// DefineGlobals defines, through define, the constants and natives every program starts with
+func DefineGlobals(define func(name string, value interface{})) {
+	define("pi", 3.141592653589793)
+	define("e", 2.718281828459045)
+
+	natives := []*Native{
+		{"siguiente", 1, nativeNext},
+		{"canal", 0, nativeChannel},
+		{"enviar", 2, nativeSend},
+		{"recibir", 1, nativeReceive},
+		{"cerrar", 1, nativeClose},
+		{"esperar", 1, nativeAwait},
+		{"entorno", 1, nativeEnvironment},
+		{"salir", 1, nativeExit},
+	}
+	for _, native := range natives {
+		define(native.Name, native)
+	}
+
+	define("argumentos", append(ArgumentList{}, Arguments...))
+}
+
+// Stalled ends the program when a native gave up waiting for the reason given
+func Stalled(t Thread, reason int) {
+	switch reason {
+	case Expired:
+		TimeUp(t)
+	case Deadlocked:
+		t.Fail(errorHandler.CodeRuntimeError, Deadlock, "[Bloqueo]")
+	}
+}
+
+// TimeUp ends the program once the time ran out, or it was cancelled, while a native was waiting
+func TimeUp(t Thread) {
+	if t.Budget().Finished() {
+		t.Stop()
+	}
+
+	code, message := t.Budget().Expired()
+	t.Fail(code, message, "[Límite]")
+}
+
+// nativeNext implements siguiente(generador), giving nulo once it is exhausted
+func nativeNext(t Thread, arguments []interface{}) interface{} {
+	g, ok := arguments[0].(*Generator)
+	if !ok {
+		t.Fail(errorHandler.CodeRuntimeError, fmt.Sprintf("siguiente espera un generador, se obtuvo %v", arguments[0]), "[Siguiente]")
+	}
+
+	value, _ := g.Next(t)
+	return value
+}
+
+// nativeAwait implements esperar(tarea), blocking until the task finishes and giving its result
+func nativeAwait(t Thread, arguments []interface{}) interface{} {
+	task, ok := arguments[0].(*Task)
+	if !ok {
+		t.Fail(errorHandler.CodeRuntimeError, fmt.Sprintf("esperar espera una tarea, se obtuvo %v", arguments[0]), "[Esperar]")
+	}
+
+	result, reason := task.Await(t)
+	Stalled(t, reason)
+	return result
+}
+
+// nativeChannel implements canal(), creating a channel without buffer
+func nativeChannel(t Thread, arguments []interface{}) interface{} {
+	return &Channel{}
+}
+
+// nativeSend implements enviar(canal, valor), waiting until someone receives it
+func nativeSend(t Thread, arguments []interface{}) interface{} {
+	c := expectChannel(t, arguments[0], "enviar")
+
+	open, reason := c.Send(t, arguments[1])
+	Stalled(t, reason)
+	if !open {
+		t.Fail(errorHandler.CodeRuntimeError, "No se puede enviar por un canal cerrado", "[Enviar]")
+	}
+	return arguments[1]
+}
+
+// nativeReceive implements recibir(canal), giving nulo once the channel is closed
+func nativeReceive(t Thread, arguments []interface{}) interface{} {
+	c := expectChannel(t, arguments[0], "recibir")
+
+	value, _, reason := c.Receive(t)
+	Stalled(t, reason)
+	return value
+}
+
+// nativeClose implements cerrar(canal)
+func nativeClose(t Thread, arguments []interface{}) interface{} {
+	c := expectChannel(t, arguments[0], "cerrar")
+
+	if !c.Close() {
+		t.Fail(errorHandler.CodeRuntimeError, "El canal ya estaba cerrado", "[Cerrar]")
+	}
+	return nil
+}
+
+// nativeEnvironment implements entorno(nombre), giving the value of an environment variable or nulo if it isn't set
+func nativeEnvironment(t Thread, arguments []interface{}) interface{} {
+	name, ok := arguments[0].(string)
+	if !ok {
+		t.Fail(errorHandler.CodeRuntimeError, fmt.Sprintf("entorno espera el nombre de una variable, se obtuvo %v", arguments[0]), "[Entorno]")
+	}
+
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+// nativeExit implements salir(codigo), ending the program with that code
+func nativeExit(t Thread, arguments []interface{}) interface{} {
+	code, ok := arguments[0].(float64)
+	if !ok || code != math.Trunc(code) || code < 0 || code > 255 {
+		t.Fail(errorHandler.CodeRuntimeError, fmt.Sprintf("salir espera un código entre 0 y 255, se obtuvo %v", arguments[0]), "[Salir]")
+	}
+
+	errorHandler.Exit(int(code))
+	t.Stop()
+	return nil
+}
+
+func expectChannel(t Thread, value interface{}, native string) *Channel {
+	c, ok := value.(*Channel)
+	if !ok {
+		t.Fail(errorHandler.CodeRuntimeError, fmt.Sprintf("%v espera un canal, se obtuvo %v", native, value), "["+native+"]")
+	}
+	return c
+}
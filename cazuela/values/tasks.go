@@ -0,0 +1,368 @@
+package values
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Generator is the result of calling a function that "producir"s values. Its body
+// runs on its own goroutine, taking turns with the consumer: only one of them runs at
+// any time, handing control back and forth through the channels. A body left waiting
+// to be resumed is unwound when the generator is closed or the run is over, so its
+// goroutine doesn't outlive the program
+type Generator struct {
+	name      string
+	body      func(*Generator)
+	resume    chan struct{}
+	yield     chan generatorStep
+	stop      chan struct{}
+	exited    chan struct{}
+	started   bool
+	finished  bool
+	abandoned bool
+}
+
+type generatorStep struct {
+	value   interface{}
+	done    bool
+	failure interface{}
+}
+
+// NewGenerator creates a generator that runs body, on its own thread of the engine, the
+// first time a value is asked for
+func NewGenerator(name string, body func(*Generator)) *Generator {
+	return &Generator{
+		name:   name,
+		body:   body,
+		resume: make(chan struct{}),
+		yield:  make(chan generatorStep),
+		stop:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+}
+
+func (g *Generator) String() string {
+	return fmt.Sprintf("<generador %v>", g.name)
+}
+
+// Next resumes the body, for a consumer running on t, until it produces another value or finishes
+func (g *Generator) Next(t Thread) (interface{}, bool) {
+	if g.finished {
+		return nil, false
+	}
+
+	if !g.started {
+		g.started = true
+		go g.run()
+	} else {
+		select {
+		case g.resume <- struct{}{}:
+		case <-g.exited:
+			// The body gave up waiting as the run has to stop, and so does the consumer
+			g.finished = true
+			TimeUp(t)
+		}
+	}
+
+	step := <-g.yield
+
+	if step.failure != nil {
+		g.finished = true
+		panic(step.failure)
+	}
+
+	if step.done {
+		g.finished = true
+		return nil, false
+	}
+
+	return step.value, true
+}
+
+// Yield hands a value to the consumer, from the body running on t, waiting until it asks
+// for the next one. The body is unwound if the generator is closed or the run is over first
+func (g *Generator) Yield(t Thread, value interface{}) {
+	g.yield <- generatorStep{value: value}
+
+	select {
+	case <-g.resume:
+	case <-g.stop:
+		g.abandoned = true
+		t.Stop()
+	case <-t.Budget().Done():
+		// The consumer reports the limit, if the run isn't over, when it notices or asks for a value
+		g.abandoned = true
+		t.Stop()
+	}
+}
+
+// Close ends a generator no more values will be asked of, such as one a "por cada" leaves
+// early, waiting for its body to be unwound. Asking it for a value afterwards gives nulo
+func (g *Generator) Close() {
+	if g.started && !g.finished {
+		close(g.stop)
+		<-g.exited
+	}
+	g.finished = true
+}
+
+func (g *Generator) run() {
+	defer close(g.exited)
+	defer func() {
+		failure := recover()
+
+		// Nobody is waiting for the body of an abandoned generator
+		if g.abandoned {
+			return
+		}
+
+		// Failures are handed to the consumer, so they are reported from its goroutine
+		if failure != nil {
+			g.yield <- generatorStep{failure: failure}
+			return
+		}
+
+		g.yield <- generatorStep{done: true}
+	}()
+
+	g.body(g)
+}
+
+// A Scheduler keeps count of the threads of a run, and of how many of them are waiting on
+// a channel or a task, to notice when all of them are and none will ever go on. Generators
+// are not counted apart, as they run in turns with the thread driving them
+type Scheduler struct {
+	live    int
+	waiting int
+	stuck   chan struct{}
+	isStuck bool
+}
+
+// NewScheduler creates the scheduler of a run, counting the thread of the main program
+func NewScheduler() *Scheduler {
+	return &Scheduler{live: 1, stuck: make(chan struct{})}
+}
+
+// lock guards channels, tasks and the counts of every scheduler. Values may outlive the
+// run that created them, as in the REPL, so they don't belong to any one scheduler
+var lock sync.Mutex
+
+// check notices, with the lock held, that every thread of the run is waiting
+func (s *Scheduler) check() {
+	if s.waiting >= s.live && !s.isStuck {
+		s.isStuck = true
+		close(s.stuck)
+	}
+}
+
+// How waiting on a channel or a task ended
+const (
+	// Woken is when what the thread waited for happened
+	Woken = iota
+	// Expired is when the run has to stop, see TimeUp
+	Expired
+	// Deadlocked is when every thread was waiting, which only the main program is told
+	Deadlocked
+)
+
+// Deadlock is the message the main program fails with when every thread is waiting
+const Deadlock = "Todas las tareas están esperando y ninguna puede continuar"
+
+// A waiter is a thread waiting on a channel or a task, along with what it sends or gets
+type waiter struct {
+	scheduler *Scheduler
+	value     interface{}
+	ok        bool
+	wake      chan struct{}
+}
+
+// enqueue adds a waiter for t to a queue, with the lock held
+func enqueue(t Thread, queue *[]*waiter, value interface{}) *waiter {
+	w := &waiter{scheduler: t.Scheduler(), value: value, wake: make(chan struct{})}
+	*queue = append(*queue, w)
+
+	w.scheduler.waiting++
+	w.scheduler.check()
+	return w
+}
+
+// dequeue takes the first waiter out of a queue, with the lock held, for it to be woken up
+func dequeue(queue *[]*waiter) *waiter {
+	w := (*queue)[0]
+	*queue = (*queue)[1:]
+
+	w.scheduler.waiting--
+	return w
+}
+
+// wakeUp lets a waiter go on, telling it whether what it waited for happened
+func (w *waiter) wakeUp(ok bool) {
+	w.ok = ok
+	close(w.wake)
+}
+
+// wait blocks t until w is woken, the run has to stop, or every thread is waiting
+func wait(t Thread, w *waiter, queue *[]*waiter) int {
+	var stuck chan struct{}
+	if !t.Task() {
+		stuck = w.scheduler.stuck
+	}
+
+	reason := Woken
+	select {
+	case <-w.wake:
+		return Woken
+	case <-t.Budget().Done():
+		reason = Expired
+	case <-stuck:
+		reason = Deadlocked
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// It may have been woken while giving up
+	select {
+	case <-w.wake:
+		return Woken
+	default:
+	}
+
+	for i, queued := range *queue {
+		if queued == w {
+			*queue = append((*queue)[:i], (*queue)[i+1:]...)
+			break
+		}
+	}
+	w.scheduler.waiting--
+	return reason
+}
+
+// A Task is a function call running concurrently on its own thread, created with "lanzar"
+type Task struct {
+	name      string
+	scheduler *Scheduler
+	finished  bool
+	result    interface{}
+	waiters   []*waiter
+}
+
+// Spawn creates a task for a call the engine is about to start, counting its thread
+func (s *Scheduler) Spawn(name string) *Task {
+	lock.Lock()
+	defer lock.Unlock()
+
+	s.live++
+	return &Task{name: name, scheduler: s}
+}
+
+func (task *Task) String() string {
+	return fmt.Sprintf("<tarea %v>", task.name)
+}
+
+// Finish records what the call gave back, letting those waiting for the task go on
+func (task *Task) Finish(result interface{}) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	task.finished, task.result = true, result
+	for len(task.waiters) > 0 {
+		dequeue(&task.waiters).wakeUp(true)
+	}
+
+	task.scheduler.live--
+	task.scheduler.check()
+}
+
+// Await waits, on t, until the task finishes, giving its result
+func (task *Task) Await(t Thread) (interface{}, int) {
+	lock.Lock()
+	if task.finished {
+		lock.Unlock()
+		return task.result, Woken
+	}
+	w := enqueue(t, &task.waiters, nil)
+	lock.Unlock()
+
+	if reason := wait(t, w, &task.waiters); reason != Woken {
+		return nil, reason
+	}
+	return task.result, Woken
+}
+
+// A Channel passes values between threads, each send waiting for a receiver
+type Channel struct {
+	closed    bool
+	senders   []*waiter
+	receivers []*waiter
+}
+
+func (c *Channel) String() string {
+	return "<canal>"
+}
+
+// Send waits, on t, until a receiver takes value, giving open false if the channel is or
+// gets closed first
+func (c *Channel) Send(t Thread, value interface{}) (open bool, reason int) {
+	lock.Lock()
+	if c.closed {
+		lock.Unlock()
+		return false, Woken
+	}
+	if len(c.receivers) > 0 {
+		receiver := dequeue(&c.receivers)
+		receiver.value = value
+		receiver.wakeUp(true)
+		lock.Unlock()
+		return true, Woken
+	}
+	w := enqueue(t, &c.senders, value)
+	lock.Unlock()
+
+	if reason := wait(t, w, &c.senders); reason != Woken {
+		return true, reason
+	}
+	return w.ok, Woken
+}
+
+// Close closes the channel, giving false if it was closed already. Those waiting on it
+// are woken: receivers get nulo, and senders fail
+func (c *Channel) Close() bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	c.closed = true
+	for len(c.receivers) > 0 {
+		dequeue(&c.receivers).wakeUp(false)
+	}
+	for len(c.senders) > 0 {
+		dequeue(&c.senders).wakeUp(false)
+	}
+	return true
+}
+
+// Receive waits, on t, for a value, which is nulo with ok false once the channel is closed
+func (c *Channel) Receive(t Thread) (value interface{}, ok bool, reason int) {
+	lock.Lock()
+	if len(c.senders) > 0 {
+		sender := dequeue(&c.senders)
+		sender.wakeUp(true)
+		lock.Unlock()
+		return sender.value, true, Woken
+	}
+	if c.closed {
+		lock.Unlock()
+		return nil, false, Woken
+	}
+	w := enqueue(t, &c.receivers, nil)
+	lock.Unlock()
+
+	if reason := wait(t, w, &c.receivers); reason != Woken {
+		return nil, false, reason
+	}
+	return w.value, w.ok, Woken
+}
@@ -0,0 +1,219 @@
+package values
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+/*
+The values Cazuela programs work with, shared by the tree-walking interpreter and the
+VM so both behave the same. Numbers are float64, strings string, booleans bool and nulo
+nil; the rest are defined here, along with the natives both engines provide. Functions
+declared in a program are the only values each engine represents its own way.
+*/
+
+// A Function is a function declared in a program, whichever engine made it
+type Function interface {
+	FunctionName() string
+}
+
+// A Range is the value of a RangeExpression, covering [Start, End) every Step
+type Range struct {
+	Start float64
+	End   float64
+	Step  float64
+}
+
+func (r Range) String() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("%v..%v", r.Start, r.End)
+	}
+	return fmt.Sprintf("%v..%v paso %v", r.Start, r.End, r.Step)
+}
+
+// Contains reports whether a number is one of the values the range goes through
+func (r Range) Contains(v float64) bool {
+	if r.Step > 0 && (v < r.Start || v >= r.End) {
+		return false
+	}
+
+	if r.Step < 0 && (v > r.Start || v <= r.End) {
+		return false
+	}
+
+	steps := (v - r.Start) / r.Step
+	return steps == math.Trunc(steps)
+}
+
+// An ArgumentList is the value of "argumentos", which "por cada" goes through
+type ArgumentList []string
+
+func (a ArgumentList) String() string {
+	return "[" + strings.Join(a, ", ") + "]"
+}
+
+func (a ArgumentList) equal(b ArgumentList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// An Iterator hands out the values a "por cada" loop goes through, one at a time
+type Iterator interface {
+	Next() (interface{}, bool)
+}
+
+type rangeIterator struct {
+	r       Range
+	current float64
+}
+
+func (it *rangeIterator) Next() (interface{}, bool) {
+	if (it.r.Step > 0 && it.current >= it.r.End) || (it.r.Step < 0 && it.current <= it.r.End) {
+		return nil, false
+	}
+
+	value := it.current
+	it.current += it.r.Step
+	return value, true
+}
+
+type stringIterator struct {
+	characters []rune
+	current    int
+}
+
+func (it *stringIterator) Next() (interface{}, bool) {
+	if it.current >= len(it.characters) {
+		return nil, false
+	}
+
+	value := string(it.characters[it.current])
+	it.current++
+	return value, true
+}
+
+type argumentIterator struct {
+	arguments ArgumentList
+	current   int
+}
+
+func (it *argumentIterator) Next() (interface{}, bool) {
+	if it.current >= len(it.arguments) {
+		return nil, false
+	}
+
+	value := it.arguments[it.current]
+	it.current++
+	return value, true
+}
+
+type generatorIterator struct {
+	t Thread
+	g *Generator
+}
+
+func (it *generatorIterator) Next() (interface{}, bool) {
+	return it.g.Next(it.t)
+}
+
+// Iterate gives an iterator over a value, for a loop running on t, false if it can't be
+// walked through. Channels are left to the engines, which have to stop waiting on them
+// when the time is up
+func Iterate(t Thread, value interface{}) (Iterator, bool) {
+	switch v := value.(type) {
+	case Range:
+		return &rangeIterator{v, v.Start}, true
+	case string:
+		return &stringIterator{[]rune(v), 0}, true
+	case *Generator:
+		return &generatorIterator{t, v}, true
+	case ArgumentList:
+		return &argumentIterator{v, 0}, true
+	}
+	return nil, false
+}
+
+// Abandon closes an iterator a "por cada" leaves before going through all of it, which
+// only matters for generators, whose bodies would otherwise wait forever
+func Abandon(it Iterator) {
+	if it, ok := it.(*generatorIterator); ok {
+		it.g.Close()
+	}
+}
+
+func Equal(a, b interface{}) bool {
+	if a == nil && b == nil {
+		return true
+	}
+
+	if a == nil || b == nil {
+		return false
+	}
+
+	// Slices can't be compared with ==, argument lists are equal when their arguments are
+	if l, ok := a.(ArgumentList); ok {
+		r, ok := b.(ArgumentList)
+		return ok && l.equal(r)
+	}
+
+	return a == b
+}
+
+func Truthy(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+
+	if b, ok := v.(bool); ok {
+		return b
+	}
+
+	return true
+}
+
+// Matches tells whether the subject of a "segun" matches the pattern of a "caso"
+func Matches(subject interface{}, pattern interface{}) bool {
+	if r, ok := pattern.(Range); ok {
+		n, isNum := subject.(float64)
+		return isNum && r.Contains(n)
+	}
+
+	return Equal(subject, pattern)
+}
+
+// TypeName names the type of a value the way Cazuela programs talk about it
+func TypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "nulo"
+	case float64:
+		return "número"
+	case string:
+		return "cadena"
+	case bool:
+		return "booleano"
+	case Function:
+		return "función"
+	case *Native:
+		return "nativa"
+	case Range:
+		return "rango"
+	case *Generator:
+		return "generador"
+	case *Task:
+		return "tarea"
+	case *Channel:
+		return "canal"
+	case ArgumentList:
+		return "argumentos"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,75 @@
+package vm
+
+import (
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/sandbox"
+	"clase-mates-computacionales/cazuela/values"
+)
+
+// Limits are enforced on every program run by Interpret, see the sandbox package
+var Limits sandbox.Limits
+
+// step counts an instruction, checking the budget as often as it asks
+func (f *fiber) step() {
+	f.steps++
+	if f.steps < f.nextCheck {
+		return
+	}
+
+	// The next interval depends on how many steps are left once these are spent
+	code, message := f.budget.Spend(f.steps)
+	f.steps, f.nextCheck = 0, f.budget.Interval()
+	f.checkLimit(code, message)
+}
+
+// checkLimit ends the program when a limit has been exceeded or it has been cancelled
+func (f *fiber) checkLimit(code int, message string) {
+	if code != errorHandler.CodeAllGood {
+		if f.budget.Finished() {
+			panic(halt{})
+		}
+		f.runtimeError(code, message, "[Límite]")
+	}
+}
+
+// Budget is that of the program the fiber is part of, for the natives of the values package
+func (f *fiber) Budget() *sandbox.Budget {
+	return f.budget
+}
+
+// Fail is runtimeError, for the natives of the values package
+func (f *fiber) Fail(code int, message string, context string) {
+	f.runtimeError(code, message, context)
+}
+
+// Stop unwinds the fiber, for the natives of the values package
+func (f *fiber) Stop() {
+	panic(halt{})
+}
+
+// Scheduler is that of the run the fiber is part of, for the natives of the values package
+func (f *fiber) Scheduler() *values.Scheduler {
+	return f.scheduler
+}
+
+// Task reports whether the fiber runs a task, for the natives of the values package
+func (f *fiber) Task() bool {
+	return f.task
+}
+
+// A channelIterator lets "por cada" receive from a channel until it is closed
+type channelIterator struct {
+	f *fiber
+	c *values.Channel
+}
+
+func (it *channelIterator) Next() (interface{}, bool) {
+	value, ok, reason := it.c.Receive(it.f)
+	switch reason {
+	case values.Expired:
+		it.f.checkLimit(it.f.budget.Expired())
+	case values.Deadlocked:
+		it.f.runtimeError(errorHandler.CodeRuntimeError, values.Deadlock, "[Bloqueo]")
+	}
+	return value, ok
+}
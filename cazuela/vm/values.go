@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"clase-mates-computacionales/cazuela/compiler"
+	"sync"
+)
+
+// A cell holds a local variable captured by a closure. Tasks may share it, so it is guarded by a lock
+type cell struct {
+	lock  sync.Mutex
+	value interface{}
+}
+
+func (c *cell) get() interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.value
+}
+
+func (c *cell) set(value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.value = value
+}
+
+// A Closure is a compiled function along with the cells it captured
+type Closure struct {
+	function *compiler.Function
+	upvalues []*cell
+}
+
+func (c *Closure) String() string {
+	return c.function.String()
+}
+
+func (c *Closure) FunctionName() string {
+	return c.function.Name
+}
@@ -0,0 +1,556 @@
+package vm
+
+import (
+	"clase-mates-computacionales/cazuela/compiler"
+	"clase-mates-computacionales/cazuela/errorHandler"
+	"clase-mates-computacionales/cazuela/parser"
+	"clase-mates-computacionales/cazuela/sandbox"
+	"clase-mates-computacionales/cazuela/values"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var ShouldPrintAllExpressions = false
+
+// Output is where "servir" writes, tools that own the standard output can point it elsewhere
+var Output io.Writer = os.Stdout
+
+// The global variables, shared by every fiber
+var globals map[string]interface{}
+var globalsLock sync.RWMutex
+
+type frame struct {
+	closure *Closure
+	ip      int
+	base    int
+}
+
+// A fiber is a stack of values and call frames: the main program, a task or a generator
+type fiber struct {
+	stack     []interface{}
+	frames    []frame
+	generator *values.Generator
+	budget    *sandbox.Budget
+	scheduler *values.Scheduler
+	task      bool
+	steps     int64
+	nextCheck int64
+}
+
+// MaxCallDepth is how many calls may be running at once in a fiber before it is
+// considered a runaway recursion
+var MaxCallDepth = 10000
+
+// halt unwinds a fiber after a runtime error has already been reported
+type halt struct{}
+
+func InitEnv() {
+	globals = make(map[string]interface{})
+	values.DefineGlobals(func(name string, value interface{}) {
+		globals[name] = value
+	})
+}
+
+// Globals copies the global variables, sorted by name, for tools that show what the program defined
+func Globals() ([]string, []interface{}) {
+	globalsLock.RLock()
+	defer globalsLock.RUnlock()
+
+	names := make([]string, 0, len(globals))
+	for name := range globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]interface{}, len(names))
+	for i, name := range names {
+		values[i] = globals[name]
+	}
+	return names, values
+}
+
+// Interpret compiles an AST and runs it on the VM until it finishes, fails, or ctx is done
+func Interpret(ctx context.Context, stmts []parser.Stmt) {
+	function := compiler.Compile(stmts)
+
+	if errorHandler.HasFatalled {
+		return
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			if _, isHalt := err.(halt); !isHalt {
+				errorHandler.RaiseError(errorHandler.CodeRuntimeError, "Error interno en tiempo de ejecución", -1, fmt.Sprintf("%v", err), true)
+			}
+		}
+	}()
+
+	budget := sandbox.NewBudget(ctx, Limits)
+	defer budget.Release()
+
+	f := &fiber{budget: budget, scheduler: values.NewScheduler()}
+	f.push(&Closure{function: function})
+	f.callValue(0)
+	f.run(0)
+}
+
+func (f *fiber) push(value interface{}) {
+	f.stack = append(f.stack, value)
+}
+
+func (f *fiber) pop() interface{} {
+	value := f.stack[len(f.stack)-1]
+	f.stack = f.stack[:len(f.stack)-1]
+	return value
+}
+
+func (f *fiber) peek(distance int) interface{} {
+	return f.stack[len(f.stack)-1-distance]
+}
+
+// runtimeError reports an error at the instruction being executed, along with the
+// calls that led to it, and unwinds the fiber
+func (f *fiber) runtimeError(code int, message string, context string) {
+	line := -1
+	if len(f.frames) > 0 {
+		fr := f.frames[len(f.frames)-1]
+		line = fr.closure.function.Chunk.Lines[fr.ip-1]
+	}
+
+	errorHandler.RaiseErrorWithTrace(code, message, line, context, f.trace(), true)
+	panic(halt{})
+}
+
+// trace lists the frames of the fiber, innermost first, each with the line it is at
+func (f *fiber) trace() []errorHandler.TraceFrame {
+	frames := make([]errorHandler.TraceFrame, 0, len(f.frames))
+	for i := len(f.frames) - 1; i >= 0; i-- {
+		fr := f.frames[i]
+		frames = append(frames, errorHandler.TraceFrame{Name: fr.closure.function.Name, Line: fr.closure.function.Chunk.Lines[fr.ip-1]})
+	}
+	return frames
+}
+
+// callValue calls the value sitting below its arguments. Closures get a new frame for
+// run to execute, everything else is done by the time it returns
+func (f *fiber) callValue(argumentCount int) {
+	callee := f.peek(argumentCount)
+
+	if closure, ok := callee.(*Closure); ok {
+		if argumentCount != closure.function.Arity {
+			f.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaban %d argumentos pero se recibieron %d", closure.function.Arity, argumentCount), "Función")
+		}
+
+		base := len(f.stack) - argumentCount - 1
+
+		if closure.function.IsGenerator {
+			generator := newGenerator(closure, f.stack[base:], f)
+			f.stack = f.stack[:base]
+			f.push(generator)
+			return
+		}
+
+		if len(f.frames) > MaxCallDepth {
+			f.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Desbordamiento de pila: más de %d llamadas anidadas", MaxCallDepth), "[Llamada]")
+		}
+
+		f.frames = append(f.frames, frame{closure, 0, base})
+		return
+	}
+
+	if native, ok := callee.(*values.Native); ok {
+		f.checkLimit(f.budget.CheckNative(native.Name))
+
+		if argumentCount != native.Arity {
+			f.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaban %d argumentos pero se recibieron %d", native.Arity, argumentCount), "Función")
+		}
+
+		arguments := make([]interface{}, argumentCount)
+		copy(arguments, f.stack[len(f.stack)-argumentCount:])
+		f.stack = f.stack[:len(f.stack)-argumentCount-1]
+		f.push(native.Function(f, arguments))
+		return
+	}
+
+	f.runtimeError(errorHandler.CodeRuntimeError, "Se intentó llamar algo que no es una función", "Función")
+}
+
+// tailCall moves a call to a closure into the frame of the caller, which has nothing
+// left to do but return its result, so recursion in tail position doesn't pile up
+// frames. Any other call is made as usual, and the OpReturn after it returns the result
+func (f *fiber) tailCall(argumentCount int) {
+	closure, ok := f.peek(argumentCount).(*Closure)
+	if !ok || closure.function.IsGenerator || argumentCount != closure.function.Arity {
+		f.callValue(argumentCount)
+		return
+	}
+
+	fr := &f.frames[len(f.frames)-1]
+	window := f.stack[len(f.stack)-argumentCount-1:]
+	copy(f.stack[fr.base:], window)
+	f.stack = f.stack[:fr.base+len(window)]
+
+	fr.closure = closure
+	fr.ip = 0
+}
+
+func newGenerator(closure *Closure, callWindow []interface{}, caller *fiber) *values.Generator {
+	stack := make([]interface{}, len(callWindow))
+	copy(stack, callWindow)
+
+	generatorFiber := &fiber{
+		stack:     stack,
+		frames:    []frame{{closure, 0, 0}},
+		budget:    caller.budget,
+		scheduler: caller.scheduler,
+		task:      caller.task,
+	}
+	return values.NewGenerator(closure.function.Name, func(g *values.Generator) {
+		generatorFiber.generator = g
+		generatorFiber.run(0)
+	})
+}
+
+// spawn runs the call sitting on top of the stack on a new fiber, replacing it with the task
+func (f *fiber) spawn(argumentCount int) {
+	window := f.stack[len(f.stack)-argumentCount-1:]
+	name := fmt.Sprintf("%v", window[0])
+	if closure, ok := window[0].(*Closure); ok {
+		name = closure.function.Name
+	}
+	task := f.scheduler.Spawn(name)
+
+	taskFiber := &fiber{stack: make([]interface{}, len(window)), budget: f.budget, scheduler: f.scheduler, task: true}
+	copy(taskFiber.stack, window)
+	f.stack = f.stack[:len(f.stack)-argumentCount-1]
+
+	go func() {
+		var result interface{}
+		defer func() {
+			task.Finish(result)
+		}()
+		defer func() {
+			if err := recover(); err != nil {
+				if _, isHalt := err.(halt); !isHalt {
+					errorHandler.RaiseError(errorHandler.CodeRuntimeError, "Error interno en la tarea", -1, fmt.Sprintf("%v", err), true)
+				}
+			}
+		}()
+
+		taskFiber.callValue(argumentCount)
+		if len(taskFiber.frames) > 0 {
+			result = taskFiber.run(0)
+		} else {
+			result = taskFiber.pop()
+		}
+	}()
+
+	f.push(task)
+}
+
+// run executes instructions until a return leaves the fiber with stopDepth frames, giving the returned value
+func (f *fiber) run(stopDepth int) interface{} {
+	fr := &f.frames[len(f.frames)-1]
+	chunk := &fr.closure.function.Chunk
+
+	readByte := func() int {
+		b := chunk.Code[fr.ip]
+		fr.ip++
+		return int(b)
+	}
+
+	readShort := func() int {
+		value := chunk.ReadShort(fr.ip)
+		fr.ip += 2
+		return value
+	}
+
+	for {
+		op := readByte()
+		f.step()
+
+		switch op {
+		case compiler.OpConstant:
+			f.push(chunk.Constants[readShort()])
+		case compiler.OpNil:
+			f.push(nil)
+		case compiler.OpTrue:
+			f.push(true)
+		case compiler.OpFalse:
+			f.push(false)
+		case compiler.OpPop:
+			f.pop()
+
+		case compiler.OpGetLocal:
+			f.push(f.stack[fr.base+readByte()])
+		case compiler.OpSetLocal:
+			f.stack[fr.base+readByte()] = f.peek(0)
+		case compiler.OpGetCell:
+			f.push(f.stack[fr.base+readByte()].(*cell).get())
+		case compiler.OpSetCell:
+			f.stack[fr.base+readByte()].(*cell).set(f.peek(0))
+		case compiler.OpDeclareLocal:
+			fr.ip++
+		case compiler.OpBoxLocal:
+			slot := fr.base + readByte()
+			f.stack[slot] = &cell{value: f.stack[slot]}
+		case compiler.OpGetUpvalue:
+			f.push(fr.closure.upvalues[readByte()].get())
+		case compiler.OpSetUpvalue:
+			fr.closure.upvalues[readByte()].set(f.peek(0))
+
+		case compiler.OpGetGlobal:
+			name := chunk.Constants[readShort()].(string)
+			globalsLock.RLock()
+			value, ok := globals[name]
+			globalsLock.RUnlock()
+			if !ok {
+				f.runtimeError(errorHandler.CodeUndefinedVariable, fmt.Sprintf("Variable %v no definida", name), "[Ejecución]")
+			}
+			f.push(value)
+		case compiler.OpSetGlobal:
+			name := chunk.Constants[readShort()].(string)
+			globalsLock.Lock()
+			_, ok := globals[name]
+			if ok {
+				globals[name] = f.peek(0)
+			}
+			globalsLock.Unlock()
+			if !ok {
+				f.runtimeError(errorHandler.CodeUndefinedVariable, fmt.Sprintf("Variable %v no definida", name), "[Ejecución]")
+			}
+		case compiler.OpDefineGlobal:
+			name := chunk.Constants[readShort()].(string)
+			globalsLock.Lock()
+			globals[name] = f.pop()
+			globalsLock.Unlock()
+
+		case compiler.OpEqual:
+			b, a := f.pop(), f.pop()
+			f.push(values.Equal(a, b))
+		case compiler.OpNotEqual:
+			b, a := f.pop(), f.pop()
+			f.push(!values.Equal(a, b))
+		case compiler.OpGreater, compiler.OpGreaterEqual, compiler.OpLess, compiler.OpLessEqual,
+			compiler.OpSubtract, compiler.OpMultiply, compiler.OpDivide, compiler.OpModulo, compiler.OpPower:
+			op := chunk.Code[fr.ip-1]
+			b, a := f.pop(), f.pop()
+			f.push(f.arithmetic(op, a, b))
+		case compiler.OpAdd:
+			b, a := f.pop(), f.pop()
+			f.push(f.add(a, b))
+		case compiler.OpNot:
+			f.push(!values.Truthy(f.pop()))
+		case compiler.OpNegate:
+			value := f.pop()
+			n, ok := value.(float64)
+			if !ok {
+				f.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaba un número para -, se obtuvo %v", value), "[Unaria]")
+			}
+			f.push(-n)
+		case compiler.OpRange:
+			end, start := f.pop(), f.pop()
+			f.push(f.makeRange(start, end, 1.0))
+		case compiler.OpRangeStep:
+			step, end, start := f.pop(), f.pop(), f.pop()
+			f.push(f.makeRange(start, end, step))
+		case compiler.OpMatch:
+			pattern, subject := f.pop(), f.pop()
+			f.push(values.Matches(subject, pattern))
+
+		case compiler.OpPrint:
+			fmt.Fprintln(Output, f.pop())
+		case compiler.OpExpression:
+			value := f.pop()
+			if ShouldPrintAllExpressions {
+				fmt.Fprintf(Output, "<| %v |>\n", value)
+			}
+
+		case compiler.OpJump:
+			offset := readShort()
+			fr.ip += offset
+		case compiler.OpJumpIfFalse:
+			offset := readShort()
+			if !values.Truthy(f.peek(0)) {
+				fr.ip += offset
+			}
+		case compiler.OpJumpIfNotNil:
+			offset := readShort()
+			if f.peek(0) != nil {
+				fr.ip += offset
+			}
+		case compiler.OpLoop:
+			offset := readShort()
+			fr.ip -= offset
+		case compiler.OpIterate:
+			f.push(f.iterate(f.pop()))
+		case compiler.OpNext:
+			it := f.stack[fr.base+readByte()].(values.Iterator)
+			offset := readShort()
+			if value, ok := it.Next(); ok {
+				f.push(value)
+			} else {
+				fr.ip += offset
+			}
+		case compiler.OpClose:
+			values.Abandon(f.stack[fr.base+readByte()].(values.Iterator))
+
+		case compiler.OpCall:
+			f.callValue(readByte())
+			fr = &f.frames[len(f.frames)-1]
+			chunk = &fr.closure.function.Chunk
+		case compiler.OpTailCall:
+			f.tailCall(readByte())
+			fr = &f.frames[len(f.frames)-1]
+			chunk = &fr.closure.function.Chunk
+		case compiler.OpSpawn:
+			f.spawn(readByte())
+		case compiler.OpClosure:
+			function := chunk.Constants[readShort()].(*compiler.Function)
+			closure := &Closure{function, make([]*cell, function.UpvalueCount)}
+			for i := range closure.upvalues {
+				isLocal, index := readByte(), readByte()
+				if isLocal == 1 {
+					closure.upvalues[i] = f.stack[fr.base+index].(*cell)
+				} else {
+					closure.upvalues[i] = fr.closure.upvalues[index]
+				}
+			}
+			f.push(closure)
+		case compiler.OpReturn:
+			result := f.pop()
+			f.stack = f.stack[:fr.base]
+			f.frames = f.frames[:len(f.frames)-1]
+
+			if len(f.frames) == stopDepth {
+				return result
+			}
+
+			f.push(result)
+			fr = &f.frames[len(f.frames)-1]
+			chunk = &fr.closure.function.Chunk
+		case compiler.OpYield:
+			f.yield(f.pop())
+		}
+	}
+}
+
+func (f *fiber) yield(value interface{}) {
+	g := f.generator
+	if g == nil {
+		f.runtimeError(errorHandler.CodeRuntimeError, "Solo se puede producir dentro de un generador", "[Producir]")
+	}
+
+	g.Yield(f, value)
+}
+
+func (f *fiber) iterate(value interface{}) values.Iterator {
+	if it, ok := values.Iterate(f, value); ok {
+		return it
+	}
+
+	if c, ok := value.(*values.Channel); ok {
+		return &channelIterator{f, c}
+	}
+
+	f.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("No se puede recorrer %v", value), "[Por cada]")
+	return nil
+}
+
+func (f *fiber) makeRange(start, end, step interface{}) interface{} {
+	s, isStartNum := start.(float64)
+	e, isEndNum := end.(float64)
+	p, isStepNum := step.(float64)
+
+	if !(isStartNum && isEndNum) {
+		f.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaban números para .., se obtuvo %v y %v", start, end), "[Rango]")
+	}
+	if !isStepNum {
+		f.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaba un número para paso, se obtuvo %v", step), "[Rango]")
+	}
+
+	if p == 0 {
+		f.runtimeError(errorHandler.CodeRuntimeError, "El paso de un rango no puede ser 0", "[Rango]")
+	}
+
+	return values.Range{Start: s, End: e, Step: p}
+}
+
+func (f *fiber) add(left, right interface{}) interface{} {
+	l, isLFloat := left.(float64)
+	r, isRFloat := right.(float64)
+
+	if isLFloat && isRFloat {
+		return l + r
+	}
+
+	leftString, isLString := left.(string)
+	rightString, isRString := right.(string)
+
+	if isLString || isRString {
+		var result string
+		if isLString && !isRString {
+			result = leftString + strconv.FormatFloat(r, 'f', -1, 64)
+		} else if !isLString && isRString {
+			result = strconv.FormatFloat(l, 'f', -1, 64) + rightString
+		} else {
+			result = leftString + rightString
+		}
+
+		f.checkLimit(f.budget.CheckString(result))
+		return result
+	}
+
+	f.runtimeError(errorHandler.CodeRuntimeError, "Se esperaba números o cadenas para +", "[Suma]")
+	return nil
+}
+
+var operatorLexemes = map[byte]string{
+	compiler.OpGreater:      ">",
+	compiler.OpGreaterEqual: ">=",
+	compiler.OpLess:         "<",
+	compiler.OpLessEqual:    "<=",
+	compiler.OpSubtract:     "-",
+	compiler.OpMultiply:     "*",
+	compiler.OpDivide:       "/",
+	compiler.OpModulo:       "%",
+	compiler.OpPower:        "^",
+}
+
+func (f *fiber) arithmetic(op byte, left, right interface{}) interface{} {
+	l, isNum := left.(float64)
+	r, isNumR := right.(float64)
+
+	if !(isNum && isNumR) {
+		f.runtimeError(errorHandler.CodeRuntimeError, fmt.Sprintf("Se esperaban números para %v, se obtuvo %v y %v", operatorLexemes[op], left, right), "[Binaria]")
+	}
+
+	switch op {
+	case compiler.OpGreater:
+		return l > r
+	case compiler.OpGreaterEqual:
+		return l >= r
+	case compiler.OpLess:
+		return l < r
+	case compiler.OpLessEqual:
+		return l <= r
+	case compiler.OpSubtract:
+		return l - r
+	case compiler.OpMultiply:
+		return l * r
+	case compiler.OpDivide:
+		return l / r
+	case compiler.OpModulo:
+		return float64(int(l) % int(r))
+	case compiler.OpPower:
+		return math.Pow(l, r)
+	}
+
+	return nil
+}